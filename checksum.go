@@ -0,0 +1,240 @@
+package oget
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Checksum is the expected digest of the file.
+type Checksum struct {
+	// the name of the algorithm, e.g. "sha256". see RegisterHash for the algorithms available.
+	Algorithm string `json:"algorithm"`
+	// the expected digest in hex. the letters are case-insensitive.
+	Digest string `json:"digest"`
+}
+
+var hashRegistry = struct {
+	mux          sync.RWMutex
+	constructors map[string]func() hash.Hash
+}{
+	constructors: map[string]func() hash.Hash{
+		"md5":        md5.New,
+		"sha1":       sha1.New,
+		"sha224":     sha256.New224,
+		"sha256":     sha256.New,
+		"sha384":     sha512.New384,
+		"sha512":     sha512.New,
+		"sha512/256": sha512.New512_256,
+		"blake2b256": newBlake2b256,
+		"blake2b512": newBlake2b512,
+		"crc32c": func() hash.Hash {
+			return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		},
+	},
+}
+
+// the unkeyed BLAKE2b, whose constructors fail only with a key longer than 64 bytes.
+func newBlake2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+func newBlake2b512() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// RegisterHash registers the constructor of a hash algorithm, so that it can be used by Checksum.
+// the name is case-insensitive and ignores "-" and "_" (e.g. "SHA-256" is "sha256").
+// the built-in algorithms are md5, sha1, sha224, sha256, sha384, sha512, sha512/256,
+// blake2b256, blake2b512 and crc32c. registering an existing name replaces it.
+//
+// the hash will be computed while downloading, and its state will be saved in the manifest
+// if it implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+func RegisterHash(algorithm string, constructor func() hash.Hash) {
+	hashRegistry.mux.Lock()
+	defer hashRegistry.mux.Unlock()
+	hashRegistry.constructors[normalizeAlgorithm(algorithm)] = constructor
+}
+
+func normalizeAlgorithm(algorithm string) string {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	return strings.NewReplacer("-", "", "_", "").Replace(algorithm)
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	hashRegistry.mux.RLock()
+	constructor, ok := hashRegistry.constructors[normalizeAlgorithm(algorithm)]
+	hashRegistry.mux.RUnlock()
+
+	if !ok {
+		return nil, errors.Errorf("unknown hash algorithm %q", algorithm)
+	}
+	return constructor(), nil
+}
+
+// returns the checksums with normalized algorithms and digests.
+// returns an error if an algorithm is not registered or a digest is not hex.
+func standardizeChecksums(checksums []Checksum) ([]Checksum, error) {
+	result := []Checksum{}
+	for _, checksum := range checksums {
+		algorithm := normalizeAlgorithm(checksum.Algorithm)
+		digest := strings.ToLower(strings.TrimSpace(checksum.Digest))
+
+		if _, err := newHash(algorithm); err != nil {
+			return nil, err
+		}
+		if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+			return nil, errors.Errorf("invalid %s digest %q", algorithm, checksum.Digest)
+		}
+		result = append(result, Checksum{Algorithm: algorithm, Digest: digest})
+	}
+	return result, nil
+}
+
+// the digests expected for an algorithm. the file matches if its digest is any of them.
+type expectedDigest struct {
+	algorithm string
+	// the digests in lowercase hex.
+	digests []string
+}
+
+func expectedDigestsOf(checksums []Checksum) []expectedDigest {
+	expected := []expectedDigest{}
+	for _, checksum := range checksums {
+		expected = append(expected, expectedDigest{
+			algorithm: checksum.Algorithm,
+			digests:   []string{checksum.Digest},
+		})
+	}
+	return expected
+}
+
+// returns the algorithms of the expected digests without duplicates.
+func algorithmsOf(expected []expectedDigest) []string {
+	algorithms := []string{}
+	for _, e := range expected {
+		found := false
+		for _, algorithm := range algorithms {
+			if algorithm == e.algorithm {
+				found = true
+				break
+			}
+		}
+		if !found {
+			algorithms = append(algorithms, e.algorithm)
+		}
+	}
+	return algorithms
+}
+
+// returns the checksum of the file in hex.
+func HashFile(path string, algorithm string) (string, error) {
+	digests, err := hashFiles([]string{path}, []string{algorithm}, nil)
+	if err != nil {
+		return "", err
+	}
+	return digests[normalizeAlgorithm(algorithm)], nil
+}
+
+// reads the files in order, and returns the digests in hex of the algorithms.
+func hashFiles(pathList []string, algorithms []string, prog *progress) (map[string]string, error) {
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{}
+
+	for _, algorithm := range algorithms {
+		h, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		hashes[normalizeAlgorithm(algorithm)] = h
+		writers = append(writers, h)
+	}
+	writer := io.MultiWriter(writers...)
+
+	for _, path := range pathList {
+		if err := hashFile(writer, path, prog); err != nil {
+			return nil, err
+		}
+	}
+	digests := map[string]string{}
+	for algorithm, h := range hashes {
+		digests[algorithm] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return digests, nil
+}
+
+func hashFile(writer io.Writer, path string, prog *progress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if prog != nil {
+		reader = prog.reader(reader)
+	}
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// returns the error of the first expected digest which does not match the digests.
+func checkDigests(expected []expectedDigest, digests map[string]string) error {
+	for _, e := range expected {
+		actual := digests[e.algorithm]
+		if slices.Contains(e.digests, actual) {
+			continue
+		}
+		err := ChecksumError{
+			Algorithm: e.algorithm,
+			Expected:  strings.Join(e.digests, " "),
+			Actual:    actual,
+		}
+		if e.algorithm == "sha512" {
+			return createSHA512Error("sha512 code does not match", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// returns the digests of the expected algorithms, one per algorithm in the order of expected.
+func verifiedDigests(expected []expectedDigest, digests map[string]string) []Checksum {
+	checksums := []Checksum{}
+	for _, e := range expected {
+		if slices.ContainsFunc(checksums, func(c Checksum) bool { return c.Algorithm == e.algorithm }) {
+			continue
+		}
+		checksums = append(checksums, Checksum{Algorithm: e.algorithm, Digest: digests[e.algorithm]})
+	}
+	return checksums
+}
+
+// ChecksumError is the error of a downloaded file whose checksum does not match the expected one.
+type ChecksumError struct {
+	// the normalized name of the algorithm, e.g. "sha256".
+	Algorithm string
+	// the expected digest in hex. several digests accepted (e.g. from an SRI string) are separated by spaces.
+	Expected string
+	// the digest of the downloaded file in hex.
+	Actual string
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum does not match: expected %s, actual %s", e.Algorithm, e.Expected, e.Actual)
+}
@@ -0,0 +1,279 @@
+package oget
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the max bytes of a sidecar file (e.g. a checksum file or a signature) to read.
+const sidecarFileLimit = 4 * 1024 * 1024
+
+// the suffixes of the sidecar files tried in order, with their algorithms.
+var checksumFileSuffixes = [][2]string{
+	{".sha512", "sha512"},
+	{".sha256", "sha256"},
+	{".sha1", "sha1"},
+	{".md5", "md5"},
+}
+
+// the names of the files listing the checksums of a directory, with their algorithms.
+var checksumListNames = [][2]string{
+	{"SHA512SUMS", "sha512"},
+	{"SHA256SUMS", "sha256"},
+	{"SHA1SUMS", "sha1"},
+	{"MD5SUMS", "md5"},
+}
+
+// ChecksumFile describes the sidecar file which publishes the checksum of the file,
+// e.g. "file.zip.sha256" or "SHA256SUMS" next to "file.zip".
+type ChecksumFile struct {
+	// the URL of the sidecar file.
+	// if the value is empty, the conventional URLs next to RemoteFile.URL are tried in order:
+	// the URL with the suffixes .sha512, .sha256, .sha1 and .md5,
+	// then SHA512SUMS, SHA256SUMS, SHA1SUMS and MD5SUMS in the same directory.
+	URL string
+	// the algorithm of the checksum.
+	// if the value is empty, it is told by the line (BSD format), guessed from the name of the sidecar file,
+	// or guessed from the length of the digest.
+	Algorithm string
+	// whether to fail if no checksum is found.
+	// if the value is false, the file will be downloaded without the checksum,
+	// even if the conventional URLs fail with other errors than 404 (e.g. 403 for the missing keys of S3).
+	// the errors of fetching the URL given, or of fetching any URL if the value is true, fail the download.
+	Required bool
+}
+
+// ChecksumNotFoundError is the error of no checksum found in the sidecar files.
+type ChecksumNotFoundError struct {
+	// the name of the file to find.
+	Name string
+	// the URLs of the sidecar files tried.
+	URLs []string
+}
+
+func (e ChecksumNotFoundError) Error() string {
+	return fmt.Sprintf("no checksum of %q found in %s", e.Name, strings.Join(e.URLs, ", "))
+}
+
+type checksumLine struct {
+	// the algorithm told by the line in BSD format. the value is empty for other formats.
+	algorithm string
+	digest    string
+	name      string
+}
+
+// fetches the checksum of the file from the sidecar files.
+// returns nil if no checksum is found and the checksum is not required.
+func (t *GettingTask) discoverChecksum(config *ChecksumFile) (*Checksum, error) {
+	names := []string{}
+	if u, err := url.Parse(t.url); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		names = append(names, path.Base(u.Path))
+	}
+	if t.filename != "" && (len(names) == 0 || names[0] != t.filename) {
+		names = append(names, t.filename)
+	}
+	sources := [][2]string{}
+	guessed := config.URL == ""
+	if guessed {
+		sources = t.checksumFileURLs()
+	} else {
+		sources = append(sources, [2]string{config.URL, guessChecksumAlgorithm(config.URL)})
+	}
+	urls := []string{}
+
+	for _, source := range sources {
+		urls = append(urls, source[0])
+		content, err := t.fetchSidecarFile(source[0])
+		if err != nil {
+			var statusError HTTPStatusError
+			if errors.As(err, &statusError) &&
+				(statusError.StatusCode == http.StatusNotFound || statusError.StatusCode == http.StatusGone) {
+				continue
+			}
+			if guessed && !config.Required && t.context.Err() == nil {
+				// the guessed file may not exist, whatever the server responds.
+				continue
+			}
+			// the checksum may be there, but can not be fetched now.
+			return nil, errors.Wrapf(err, "failed to fetch checksum file %q", source[0])
+		}
+		line := selectChecksumLine(parseChecksumFile(string(content)), names)
+		if line == nil {
+			continue
+		}
+		algorithm := line.algorithm
+		if algorithm == "" {
+			algorithm = config.Algorithm
+		}
+		if algorithm == "" {
+			algorithm = source[1]
+		}
+		checksums, err := standardizeChecksums([]Checksum{{
+			Algorithm: algorithmOfDigest(algorithm, line.digest),
+			Digest:    line.digest,
+		}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid checksum file %q", source[0])
+		}
+		return &checksums[0], nil
+	}
+	if !config.Required {
+		return nil, nil
+	}
+	name := ""
+	if len(names) > 0 {
+		name = names[0]
+	}
+	return nil, ChecksumNotFoundError{Name: name, URLs: urls}
+}
+
+// returns the conventional URLs of the sidecar files next to the file, with their algorithms.
+// the query and the fragment of the file are dropped, because they are signed for the file (e.g. a presigned URL).
+func (t *GettingTask) checksumFileURLs() [][2]string {
+	u, err := url.Parse(t.url)
+	if err != nil {
+		return nil
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+	sources := [][2]string{}
+	for _, suffix := range checksumFileSuffixes {
+		sidecar := *u
+		sidecar.Path += suffix[0]
+		sidecar.RawPath = ""
+		sources = append(sources, [2]string{sidecar.String(), suffix[1]})
+	}
+	for _, name := range checksumListNames {
+		list := *u
+		list.Path = path.Join(path.Dir(u.Path), name[0])
+		list.RawPath = ""
+		sources = append(sources, [2]string{list.String(), name[1]})
+	}
+	return sources
+}
+
+// fetches the small file published next to the file, with the settings of RemoteFile.
+func (t *GettingTask) fetchSidecarFile(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(t.context, t.timeout)
+	defer cancel()
+
+	req, err := t.createRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %q", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, createHTTPStatusError(resp)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, sidecarFileLimit))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", url)
+	}
+	return content, nil
+}
+
+var bsdChecksumLine = regexp.MustCompile(`^([A-Za-z0-9/_-]+) ?\((.*)\) ?= ?([0-9A-Fa-f]+)$`)
+
+// parses the lines of the formats written by coreutils, e.g. sha256sum:
+//
+//   - GNU format, `<digest>  <name>` (text mode) or `<digest> *<name>` (binary mode).
+//     a name with a backslash or a newline is escaped, and the line starts with a backslash.
+//   - BSD format (--tag), `SHA256 (<name>) = <digest>`.
+//   - a bare digest.
+func parseChecksumFile(content string) []checksumLine {
+	lines := []checksumLine{}
+	for _, text := range strings.Split(content, "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(text, "\\")
+		if escaped {
+			text = text[1:]
+		}
+		var line checksumLine
+
+		if match := bsdChecksumLine.FindStringSubmatch(text); match != nil {
+			line = checksumLine{algorithm: match[1], digest: match[3], name: match[2]}
+		} else {
+			digest, name, _ := strings.Cut(text, " ")
+			if len(name) > 0 && (name[0] == ' ' || name[0] == '*') {
+				name = name[1:]
+			}
+			line = checksumLine{digest: digest, name: name}
+		}
+		if escaped {
+			line.name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(line.name)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// returns the line of the file names. a single line without a name is for the file.
+func selectChecksumLine(lines []checksumLine, names []string) *checksumLine {
+	for i := range lines {
+		lineName := path.Base(strings.TrimPrefix(lines[i].name, "./"))
+		for _, name := range names {
+			if lines[i].name == name || lineName == name {
+				return &lines[i]
+			}
+		}
+	}
+	if len(lines) == 1 && lines[0].name == "" {
+		return &lines[0]
+	}
+	return nil
+}
+
+// guesses the algorithm from the name of the sidecar file, e.g. "file.sha256" or "SHA256SUMS".
+func guessChecksumAlgorithm(url string) string {
+	name := strings.ToLower(path.Base(strings.SplitN(url, "?", 2)[0]))
+	for _, algorithm := range []string{"sha512", "sha384", "sha256", "sha224", "sha1", "md5"} {
+		if strings.Contains(name, algorithm) {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// returns the algorithm with the length of its digest if the algorithm is unknown,
+// e.g. "BLAKE2b" of b2sum, or the algorithm is not told at all.
+func algorithmOfDigest(algorithm string, digest string) string {
+	if algorithm != "" {
+		if _, err := newHash(algorithm); err == nil {
+			return algorithm
+		}
+		if normalizeAlgorithm(algorithm) == "blake2b" {
+			return fmt.Sprintf("blake2b%d", len(digest)*4)
+		}
+		return algorithm
+	}
+	switch len(digest) {
+	case 128:
+		return "sha512"
+	case 96:
+		return "sha384"
+	case 64:
+		return "sha256"
+	case 40:
+		return "sha1"
+	case 32:
+		return "md5"
+	}
+	return ""
+}
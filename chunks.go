@@ -0,0 +1,277 @@
+package oget
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Chunks describes the hashes of the fixed-size chunks of the file.
+// every part is verified by the chunks it covers as soon as it is downloaded,
+// and only the corrupted chunks are downloaded again.
+// every chunk is downloaded up to Retry.MaxAttempts times, including the download of its part,
+// so the default RetryPolicy (one attempt) verifies the chunks but never repairs them.
+// the chunks are ignored if the server does not support range requests.
+type Chunks struct {
+	// the algorithm of the hashes, e.g. "sha256". see RegisterHash for the algorithms available.
+	Algorithm string `json:"algorithm"`
+	// the size of each chunk (bytes). the last chunk may be shorter.
+	Size int64 `json:"size"`
+	// the digests in hex of the chunks in order.
+	Digests []string `json:"digests"`
+	// the URL of the JSON of the chunks (with the fields above), fetched before downloading.
+	// it is only used if Digests is empty.
+	URL string `json:"-"`
+}
+
+// loads the chunks from the URL if the digests are not given, and checks them with the content length.
+func (t *GettingTask) loadChunks(config *Chunks) (*Chunks, error) {
+	chunks := *config
+	if len(chunks.Digests) == 0 && chunks.URL != "" {
+		content, err := t.fetchSidecarFile(chunks.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get chunks")
+		}
+		if err := json.Unmarshal(content, &chunks); err != nil {
+			return nil, errors.Wrap(err, "invalid chunks")
+		}
+	}
+	chunks.Algorithm = normalizeAlgorithm(chunks.Algorithm)
+	if _, err := newHash(chunks.Algorithm); err != nil {
+		return nil, err
+	}
+	if chunks.Size <= 0 {
+		return nil, errors.Errorf("invalid chunk size %d", chunks.Size)
+	}
+	if count := (t.contentLength + chunks.Size - 1) / chunks.Size; int64(len(chunks.Digests)) != count {
+		return nil, errors.Errorf("chunks have %d digests, but the file has %d chunks", len(chunks.Digests), count)
+	}
+	digests := []string{}
+	for _, digest := range chunks.Digests {
+		digest = strings.ToLower(strings.TrimSpace(digest))
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, errors.Errorf("invalid chunk digest %q", digest)
+		}
+		digests = append(digests, digest)
+	}
+	chunks.Digests = digests
+	return &chunks, nil
+}
+
+// verifies the chunks of a downloading, and repairs the corrupted ones.
+type chunkVerifier struct {
+	mux    sync.Mutex
+	chunks *Chunks
+	// whether the chunk is verified, or is being verified by a part.
+	claimed []bool
+}
+
+func newChunkVerifier(chunks *Chunks) *chunkVerifier {
+	return &chunkVerifier{
+		chunks:  chunks,
+		claimed: make([]bool, len(chunks.Digests)),
+	}
+}
+
+// returns the first and the last byte of the chunk.
+func (v *chunkVerifier) chunkRange(index int, contentLength int64) (int64, int64) {
+	begin := int64(index) * v.chunks.Size
+	return begin, min(begin+v.chunks.Size, contentLength) - 1
+}
+
+// verifies the chunks in the range [begin, end] whose bytes are all saved, and repairs the corrupted ones.
+// the chunks verified by other parts are skipped.
+func (t *GettingTask) verifyChunks(ctx context.Context, c *GettingConfig, manifest *manifestFile, begin int64, end int64) error {
+	v := manifest.chunks
+	for index := int(begin / v.chunks.Size); index < len(v.claimed); index++ {
+		chunkBegin, chunkEnd := v.chunkRange(index, t.contentLength)
+		if chunkBegin > end {
+			break
+		}
+		if !manifest.saved(chunkBegin, chunkEnd) {
+			continue
+		}
+		v.mux.Lock()
+		claimed := v.claimed[index]
+		v.claimed[index] = true
+		v.mux.Unlock()
+
+		if claimed {
+			continue
+		}
+		if err := t.verifyChunk(ctx, c, manifest, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *GettingTask) verifyChunk(ctx context.Context, c *GettingConfig, manifest *manifestFile, index int) error {
+	v := manifest.chunks
+	begin, end := v.chunkRange(index, t.contentLength)
+	expected := v.chunks.Digests[index]
+
+	// the download of the part is the first attempt, and every repair is the next one, as in Retry.MaxAttempts.
+	// the first repair is not delayed, and switching to another mirror neither takes an attempt nor is delayed.
+	for attempt := 1; ; {
+		actual, err := t.hashChunk(c, manifest, begin, end)
+		if err != nil {
+			return err
+		}
+		if actual == expected {
+			return nil
+		}
+		if attempt >= c.Retry.MaxAttempts {
+			return errors.Wrapf(ChecksumError{
+				Algorithm: v.chunks.Algorithm,
+				Expected:  expected,
+				Actual:    actual,
+			}, "chunk %d (bytes %d-%d) is corrupted", index, begin, end)
+		}
+		if manifest.chain != nil {
+			// the chain has hashed the corrupted bytes, the files will be hashed after downloading.
+			manifest.chain.invalidate()
+		}
+		for failover := false; ; {
+			if attempt > 1 && !failover {
+				if err := sleepWithContext(ctx, c.Retry.backoff(attempt)); err != nil {
+					return err
+				}
+			}
+			if err := t.pauser.wait(ctx); err != nil {
+				return err
+			}
+			failover, err = t.repairChunk(ctx, c, manifest, begin, end)
+			if failover {
+				continue
+			}
+			attempt++
+			if err == nil {
+				break
+			}
+			if !IsRetryable(err) || attempt >= c.Retry.MaxAttempts {
+				return err
+			}
+		}
+	}
+}
+
+func (t *GettingTask) hashChunk(c *GettingConfig, manifest *manifestFile, begin int64, end int64) (string, error) {
+	h, err := newHash(manifest.chunks.chunks.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	for _, piece := range t.storagePieces(c, manifest, begin, end) {
+		file, err := os.Open(piece.path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, io.NewSectionReader(file, piece.offset, piece.length))
+		file.Close()
+
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// downloads the bytes [begin, end] again from a mirror, and writes them over the saved ones.
+// returns true if the mirror is failed and the bytes should be downloaded from another mirror.
+func (t *GettingTask) repairChunk(ctx context.Context, c *GettingConfig, manifest *manifestFile, begin int64, end int64) (bool, error) {
+	m := t.mirrors.acquire()
+	watcher := watchStall(ctx, m.url, t.stallTimeout)
+	beginTime := time.Now()
+	written, err := t.repairChunkFrom(watcher, m, c, manifest, begin, end)
+	watcher.stop()
+
+	mirrorErr := err
+	if ctx.Err() != nil {
+		// the mirror is not blamed for the canceling.
+		mirrorErr = nil
+	}
+	return t.mirrors.release(m, written, time.Since(beginTime), mirrorErr), err
+}
+
+func (t *GettingTask) repairChunkFrom(watcher *stallWatcher, m *mirror, c *GettingConfig, manifest *manifestFile, begin int64, end int64) (int64, error) {
+	req, err := t.createRequest(watcher.ctx, m.url)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", begin, end))
+	if ifRange := m.ifRangeValidator(); ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		err = watcher.check(err)
+		return 0, errors.Wrapf(err, "failed to get response: %q", err)
+	}
+	defer resp.Body.Close()
+
+	if err := t.checkResponse(req, resp, &subTask{begin: begin, end: end}, m); err != nil {
+		return 0, err
+	}
+	body := limitReader(req.Context(), watcher.reader(resp.Body), t.limiter, t.sharedLimiter)
+	total := int64(0)
+
+	for _, piece := range t.storagePieces(c, manifest, begin, end) {
+		file, err := os.OpenFile(piece.path, os.O_WRONLY, 0666)
+		if err != nil {
+			return total, errors.Wrapf(err, "failed to write file")
+		}
+		written, err := io.CopyN(io.NewOffsetWriter(file, piece.offset), body, piece.length)
+		file.Close()
+		total += written
+
+		if err == io.EOF {
+			return total, ContentLengthError{Expected: end - begin + 1, Actual: total}
+		}
+		if err != nil {
+			return total, errors.Wrapf(watcher.check(err), "failed to write response body")
+		}
+	}
+	return total, nil
+}
+
+// a piece of the storage which holds some bytes of the file.
+type storagePiece struct {
+	path string
+	// the offset of the piece in the storage file.
+	offset int64
+	length int64
+}
+
+// returns the pieces of the storage which hold the bytes [begin, end] of the file.
+func (t *GettingTask) storagePieces(c *GettingConfig, manifest *manifestFile, begin int64, end int64) []storagePiece {
+	if c.Storage == StoragePreallocated {
+		return []storagePiece{{
+			path:   filepath.Join(c.PartsPath, c.preallocatedFileName()),
+			offset: begin,
+			length: end - begin + 1,
+		}}
+	}
+	pieces := []storagePiece{}
+	for i := 0; i < c.Parts; i++ {
+		part := manifest.part(i)
+		pieceBegin, pieceEnd := max(begin, part.Begin), min(end, part.End)
+		if pieceBegin > pieceEnd {
+			continue
+		}
+		pieces = append(pieces, storagePiece{
+			path:   filepath.Join(c.PartsPath, part.File),
+			offset: pieceBegin - part.Begin,
+			length: pieceEnd - pieceBegin + 1,
+		})
+	}
+	return pieces
+}
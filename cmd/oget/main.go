@@ -0,0 +1,223 @@
+// oget downloads a file in parallel parts, and resumes it when run again after a failure.
+//
+//	oget [flags] <URL>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/oomol-lab/oget"
+)
+
+const (
+	exitOK = 0
+	// the file can not be written, e.g. the disk is full.
+	exitFailure = 1
+	exitUsage   = 2
+	// the file can not be downloaded, e.g. the server is unreachable or responds an error.
+	exitNetwork = 3
+	// the file is downloaded, but does not match its checksum.
+	exitIntegrity   = 4
+	exitInterrupted = 130
+)
+
+// the error of the flags, which has been printed with the usage by the flag package.
+var errInvalidFlags = errors.New("invalid flags")
+
+// the error of a URL without the file name, which must be set by -o.
+var errNoFileName = errors.New("can not tell the file name from the URL, please set it by -o")
+
+type options struct {
+	url       string
+	output    string
+	parts     int
+	partsPath string
+	sha512    string
+	useragent string
+	referer   string
+	timeout   time.Duration
+	quiet     bool
+	json      bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	opts, err := parseOptions(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		if err != errInvalidFlags {
+			fmt.Fprintf(os.Stderr, "oget: %s\n", err)
+		}
+		return exitUsage
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var encoder *oget.JSONLinesEncoder
+	if opts.json {
+		encoder = oget.NewJSONLinesEncoder(os.Stdout)
+	}
+	result, err := download(ctx, opts, encoder)
+	code := exitCodeOf(err)
+
+	if encoder != nil {
+		_ = encoder.EncodeResult(result, err)
+	}
+
+	switch code {
+	case exitOK:
+	case exitInterrupted:
+		fmt.Fprintln(os.Stderr, "oget: interrupted, run again to resume")
+	case exitNetwork:
+		fmt.Fprintf(os.Stderr, "oget: %s\noget: run again to resume\n", err)
+	default:
+		fmt.Fprintf(os.Stderr, "oget: %s\n", err)
+	}
+	return code
+}
+
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	flags := flag.NewFlagSet("oget", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: oget [flags] <URL>\n\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.url, "url", "", "the URL of the file to download, instead of the argument")
+	flags.StringVar(&opts.output, "o", "", "the path (or the existing directory) to save the file\n(default: the name of the file in the current directory)")
+	flags.IntVar(&opts.parts, "parts", 1, "the number of parts to download in parallel")
+	flags.StringVar(&opts.partsPath, "parts-path", "", "the directory to save the parts (default: the directory of the file)")
+	flags.StringVar(&opts.sha512, "sha512", "", "the SHA512 checksum in hex to verify the file")
+	flags.StringVar(&opts.useragent, "user-agent", "", "the User-Agent header")
+	flags.StringVar(&opts.referer, "referer", "", "the Referer header")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "the timeout of probing the file before downloading, which does not bound the downloading")
+	flags.BoolVar(&opts.quiet, "q", false, "do not show the progress")
+	flags.BoolVar(&opts.json, "json", false, "write the progress and the result to the standard output as JSON lines")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errInvalidFlags
+	}
+	switch {
+	case flags.NArg() > 1:
+		return nil, errors.New("too many arguments")
+	case flags.NArg() == 1 && opts.url != "":
+		return nil, errors.New("the URL is given twice")
+	case flags.NArg() == 1:
+		opts.url = flags.Arg(0)
+	case opts.url == "":
+		flags.Usage()
+		return nil, errors.New("the URL is required")
+	}
+	if opts.parts <= 0 {
+		return nil, errors.New("the number of parts must be positive")
+	}
+	return opts, nil
+}
+
+// downloads the file and returns the result. the result is nil if the download fails before the path is known.
+func download(ctx context.Context, opts *options, encoder *oget.JSONLinesEncoder) (*oget.Result, error) {
+	task, err := oget.CreateGettingTask(&oget.RemoteFile{
+		Context:   ctx,
+		Timeout:   opts.timeout,
+		URL:       opts.url,
+		Useragent: opts.useragent,
+		Referer:   opts.referer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := outputPath(opts.output, opts.url, task.FileName())
+	if err != nil {
+		return nil, err
+	}
+	config := &oget.GettingConfig{
+		FilePath:  filePath,
+		SHA512:    opts.sha512,
+		PartsPath: opts.partsPath,
+		Parts:     opts.parts,
+	}
+	var bar *progressBar
+	if encoder != nil {
+		config.ListenProgress = encoder.Listen
+	} else if !opts.quiet && isTerminal(os.Stderr) {
+		bar = newProgressBar(os.Stderr)
+		config.ListenProgress = bar.listen
+	}
+	result, err := task.Get(config)
+
+	if bar != nil {
+		bar.finish()
+	}
+	if err != nil && exitCodeOf(err) == exitIntegrity {
+		// the parts are complete but wrong, nothing is worth resuming.
+		_ = result.Clean()
+	}
+	if err == nil && !opts.quiet && encoder == nil {
+		fmt.Fprintf(os.Stderr, "saved to %s (%s in %s, %s/s)\n", result.FilePath,
+			formatBytes(result.Downloaded), result.DownloadDuration.Round(time.Millisecond),
+			formatBytes(int64(result.AverageSpeed)))
+	}
+	return result, err
+}
+
+// returns the path to save the file. the name is taken from the Content-Disposition header or the URL
+// if the output is empty or an existing directory.
+func outputPath(output string, rawURL string, fileName string) (string, error) {
+	if output != "" {
+		info, err := os.Stat(output)
+		if err != nil || !info.IsDir() {
+			return output, nil
+		}
+	}
+	name := filepath.Base(fileName)
+	if fileName == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", err
+		}
+		name = path.Base(u.Path)
+	}
+	if name == "." || name == "/" || name == "" {
+		return "", errNoFileName
+	}
+	return filepath.Join(output, name), nil
+}
+
+func exitCodeOf(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, errNoFileName) {
+		return exitUsage
+	}
+	switch oget.ErrorKindOf(err) {
+	case oget.ErrorKindCanceled:
+		return exitInterrupted
+	case oget.ErrorKindConfig:
+		return exitUsage
+	case oget.ErrorKindChecksum, oget.ErrorKindChecksumNotFound, oget.ErrorKindSignature:
+		return exitIntegrity
+	case oget.ErrorKindFilesystem:
+		return exitFailure
+	default:
+		return exitNetwork
+	}
+}
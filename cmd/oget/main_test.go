@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oomol-lab/oget"
+)
+
+func TestParseOptions(t *testing.T) {
+	// the usage is printed to the standard error.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open %s fail: %s", os.DevNull, err)
+	}
+	defer devNull.Close()
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	cases := []struct {
+		args []string
+		opts *options
+		err  error
+	}{
+		{
+			args: []string{"https://example.com/file.bin"},
+			opts: &options{url: "https://example.com/file.bin", parts: 1, timeout: 10 * time.Second},
+		},
+		{
+			args: []string{"-url", "https://example.com/file.bin", "-parts", "4", "-timeout", "3s", "-q", "-json"},
+			opts: &options{url: "https://example.com/file.bin", parts: 4, timeout: 3 * time.Second, quiet: true, json: true},
+		},
+		{
+			args: []string{"-o", "out.bin", "-parts-path", "parts", "-sha512", "abc", "https://example.com/file.bin"},
+			opts: &options{url: "https://example.com/file.bin", output: "out.bin", partsPath: "parts", sha512: "abc", parts: 1, timeout: 10 * time.Second},
+		},
+		{args: []string{"-h"}, err: flag.ErrHelp},
+		{args: []string{"-unknown", "https://example.com/file.bin"}, err: errInvalidFlags},
+		{args: []string{"-parts", "many", "https://example.com/file.bin"}, err: errInvalidFlags},
+		{args: []string{"https://example.com/a.bin", "https://example.com/b.bin"}, err: errors.New("too many arguments")},
+		{args: []string{"-url", "https://example.com/a.bin", "https://example.com/a.bin"}, err: errors.New("the URL is given twice")},
+		{args: []string{}, err: errors.New("the URL is required")},
+		{args: []string{"-parts", "0", "https://example.com/file.bin"}, err: errors.New("the number of parts must be positive")},
+	}
+	for _, c := range cases {
+		opts, err := parseOptions(c.args)
+		if c.err != nil {
+			if err == nil || (!errors.Is(err, c.err) && err.Error() != c.err.Error()) {
+				t.Fatalf("unexpected error of %q: %v", c.args, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse %q fail: %s", c.args, err)
+		}
+		if *opts != *c.opts {
+			t.Fatalf("unexpected options of %q: %+v", c.args, opts)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	dirPath := t.TempDir()
+	cases := []struct {
+		output   string
+		url      string
+		fileName string
+		path     string
+		err      error
+	}{
+		{url: "https://example.com/file.bin", path: "file.bin"},
+		{url: "https://example.com/download?id=1", fileName: "named.bin", path: "named.bin"},
+		{url: "https://example.com/file.bin", fileName: "../../escaped.bin", path: "escaped.bin"},
+		{url: "https://example.com/file.bin", fileName: "..", path: "file.bin"},
+		{output: "out.bin", url: "https://example.com/file.bin", fileName: "named.bin", path: "out.bin"},
+		{output: dirPath, url: "https://example.com/file.bin", path: filepath.Join(dirPath, "file.bin")},
+		{output: dirPath, url: "https://example.com/file.bin", fileName: "named.bin", path: filepath.Join(dirPath, "named.bin")},
+		{url: "https://example.com/", err: errNoFileName},
+		{url: "https://example.com", err: errNoFileName},
+	}
+	for _, c := range cases {
+		path, err := outputPath(c.output, c.url, c.fileName)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Fatalf("unexpected error of %+v: %v", c, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("output path of %+v fail: %s", c, err)
+		}
+		if path != c.path {
+			t.Fatalf("unexpected output path of %+v: %s", c, path)
+		}
+	}
+}
+
+func TestExitCodeOf(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOK},
+		{errNoFileName, exitUsage},
+		{oget.ConfigError{Err: errors.New("invalid sha512 digest")}, exitUsage},
+		{fmt.Errorf("failed to download: %w", context.Canceled), exitInterrupted},
+		{oget.ChecksumError{Algorithm: "sha512"}, exitIntegrity},
+		{oget.ChecksumNotFoundError{Name: "file.bin"}, exitIntegrity},
+		{oget.SignatureError{Err: errors.New("signature does not match")}, exitIntegrity},
+		{&fs.PathError{Op: "write", Path: "file.bin", Err: errors.New("no space left on device")}, exitFailure},
+		{oget.HTTPStatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}, exitNetwork},
+		{context.DeadlineExceeded, exitNetwork},
+		{errors.New("connection refused"), exitNetwork},
+	}
+	for _, c := range cases {
+		if code := exitCodeOf(c.err); code != c.code {
+			t.Fatalf("unexpected exit code of %v: %d", c.err, code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/oomol-lab/oget"
+)
+
+const progressBarWidth = 30
+
+// renders the progress on one line of the terminal.
+// the events are coalesced by GettingConfig.ProgressInterval, so every one is rendered.
+type progressBar struct {
+	output io.Writer
+	// the length of the last line, to overwrite it.
+	lineLength int
+}
+
+func newProgressBar(output io.Writer) *progressBar {
+	return &progressBar{output: output}
+}
+
+func (b *progressBar) listen(event oget.ProgressEvent) {
+	b.render(event)
+}
+
+// ends the line. all events have been delivered once Get returns.
+func (b *progressBar) finish() {
+	if b.lineLength > 0 {
+		fmt.Fprintln(b.output)
+	}
+}
+
+func (b *progressBar) render(event oget.ProgressEvent) {
+	line := fmt.Sprintf("%-11s ", phaseName(event.Phase))
+
+	if event.Total > 0 {
+		ratio := min(float64(event.Progress)/float64(event.Total), 1)
+		filled := int(ratio * progressBarWidth)
+		line += fmt.Sprintf("[%s%s] %5.1f%% %s / %s",
+			strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+			ratio*100, formatBytes(event.Progress), formatBytes(event.Total))
+	} else {
+		line += formatBytes(event.Progress)
+	}
+	if event.Phase != oget.ProgressPhaseDone {
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(event.Speed)))
+		if event.ETA > 0 {
+			line += fmt.Sprintf("  ETA %s", event.ETA.Round(time.Second))
+		}
+	}
+	padding := max(0, b.lineLength-len(line))
+	fmt.Fprintf(b.output, "\r%s%s", line, strings.Repeat(" ", padding))
+	b.lineLength = len(line)
+}
+
+func phaseName(phase oget.ProgressPhase) string {
+	switch phase {
+	case oget.ProgressPhaseDownloading:
+		return "downloading"
+	case oget.ProgressPhaseVerifying:
+		return "verifying"
+	case oget.ProgressPhaseCoping:
+		return "merging"
+	default:
+		return "done"
+	}
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	suffixes := []string{"KiB", "MiB", "GiB", "TiB"}
+	index := -1
+	for value >= unit && index < len(suffixes)-1 {
+		value /= unit
+		index++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[index])
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
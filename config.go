@@ -3,6 +3,7 @@ package oget
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"time"
 )
@@ -16,6 +17,15 @@ type RemoteFile struct {
 	Timeout time.Duration
 	// the URL of the file to download.
 	URL string
+	// the URLs of the mirrors which serve the same file as URL.
+	// they are probed with URL, and the parts are spread across the healthy ones.
+	// a part is downloaded from another mirror if one fails or stalls (see StallTimeout).
+	// if the value is empty, the file is only downloaded from URL.
+	Mirrors []string
+	// the maximum time to wait for the next bytes of a response, before downloading them from another mirror
+	// (or retrying by the RetryPolicy if there is no other mirror).
+	// if the value is less than or equal to 0, a response never stalls.
+	StallTimeout time.Duration
 	// the User-Agent header field value.
 	// if the value is empty, the User-Agent header will not be set.
 	Useragent string
@@ -23,16 +33,68 @@ type RemoteFile struct {
 	// if the value is empty, the Referer header will not be set.
 	Referer string
 	// the maximum number of idle (keep-alive) connections to keep per-host.
-	// the default is 16.
+	// the default is 16. it is ignored if Client is set.
 	MaxIdleConnsPerHost int
+	// the client to send the requests, which can be shared by the tasks to reuse the connections.
+	// it should not decompress the responses, or the downloaded file will be decompressed.
+	// if the value is nil, a new client will be created for the task.
+	Client *http.Client
+	// the way to get the file information from the server.
+	// the default is ProbeHeadThenGet.
+	Probe ProbeMethod
+	// the maximum bytes per second to download the file.
+	// if the value is less than or equal to 0, the bandwidth is unlimited (except for RateLimiter).
+	// it can be changed while downloading by GettingTask.SetRateLimit.
+	RateLimit int64
+	// the maximum bytes to read at once under the RateLimit.
+	// if the value is less than or equal to 0, it is the same as RateLimit.
+	RateBurst int64
+	// the limiter shared with other tasks, to limit their total bandwidth.
+	// if the value is nil, the bandwidth is only limited by RateLimit.
+	RateLimiter *RateLimiter
 }
 
+// StorageMode is the way to store the parts during downloading.
+type StorageMode int
+
+const (
+	// StoragePartFiles saves every part into its own file, and merges them into the target file at last.
+	StoragePartFiles StorageMode = iota
+	// StoragePreallocated writes every part at its offset of one file preallocated to the content length,
+	// and renames it to the target file at last. it saves the disk I/O and space of merging.
+	// it falls back to StoragePartFiles if the server does not support range requests or tell the length.
+	StoragePreallocated
+)
+
 type GettingConfig struct {
 	// the path to save the downloaded file.
 	FilePath string
 	// the SHA512 code of the file.
 	// if the code is empty, the file will not be checked.
+	// it is the same as a Checksum of the "sha512" algorithm.
 	SHA512 string
+	// the checksums of the file. the file must match all of them.
+	// if the value is empty, the file will not be checked (except for SHA512).
+	Checksums []Checksum
+	// the Subresource Integrity string of the file, e.g. "sha512-<base64>".
+	// it may contain several tokens separated by spaces, and only the strongest algorithm of them is checked.
+	// if the value is empty, the file will not be checked by it.
+	Integrity string
+	// whether to check the file against the digests told by the server (see GettingTask.Digests).
+	// a file is not checked if the server does not tell any digest.
+	VerifyServerDigest bool
+	// the sidecar file to fetch the checksum from before downloading (e.g. "file.zip.sha256" or "SHA256SUMS").
+	// if the value is nil, no sidecar file will be fetched.
+	ChecksumFile *ChecksumFile
+	// the detached signature to verify the file before moving it to the FilePath.
+	// raw Ed25519 and legacy minisign signatures read the whole file into the memory (up to DefaultMaxSignedSize by default),
+	// while prehashed minisign signatures are verified as a stream.
+	// if the value is nil, the signature will not be verified.
+	Signature *Signature
+	// the hashes of the chunks of the file, to verify every part as soon as it is downloaded
+	// and download only the corrupted chunks again.
+	// if the value is nil, the parts will not be verified.
+	Chunks *Chunks
 	// PartsPath is the path to save the temp files of downloaded parts.
 	// if the value is empty, the temp files will be saved in the same directory as the FilePath.
 	PartsPath string
@@ -42,9 +104,32 @@ type GettingConfig struct {
 	// the number of parts to download the file.
 	// if the value is less than or equal to 0, the file will be downloaded in one part.
 	Parts int
-	// the progress listener.
+	// the way to store the parts during downloading.
+	// the default is StoragePartFiles.
+	Storage StorageMode
+	// the progress listener. it is never called concurrently, and all events are delivered before Get returns.
 	// if the value is nil, the progress will not be listened.
 	ListenProgress ProgressListener
+	// the channel to receive the progress events, as an alternative to ListenProgress.
+	// the downloading is not slowed if it is full, the events are coalesced until they are received.
+	// but Get does not return until all events have been sent, so the channel must be received until Get returns.
+	// only once RemoteFile.Context is done, the events which are not received at once are dropped.
+	// it is never closed by Get, and can be reused by the next call.
+	// if the value is nil, the events will not be sent.
+	ProgressChannel chan<- ProgressEvent
+	// the minimum interval between two events delivered to ListenProgress and ProgressChannel.
+	// the events in between are coalesced into the latest one, while the first and the last events
+	// of every phase are always delivered.
+	// the default is 100 milliseconds. if the value is negative, every event is delivered,
+	// unless the listener falls behind by too many events, which are then coalesced.
+	ProgressInterval time.Duration
+	// the policy to retry a failed part of the downloading.
+	// if the value is zero, the part will not be retried.
+	Retry RetryPolicy
+	// the listener called before a failed part is retried,
+	// or before all parts are restarted because the remote file changed.
+	// if the value is nil, the retrying will not be listened.
+	ListenRetry RetryListener
 }
 
 func (config *RemoteFile) standardize() RemoteFile {
@@ -82,6 +167,27 @@ func (c *GettingConfig) partFileName(index int) string {
 	return fileName
 }
 
+func (c *GettingConfig) preallocatedFileName() string {
+	return fmt.Sprintf("%s.downloading", c.PartName)
+}
+
+// returns the name of the file which the part is written into.
+func (c *GettingConfig) storageFileName(index int) string {
+	if c.Storage == StoragePreallocated {
+		return c.preallocatedFileName()
+	}
+	return c.partFileName(index)
+}
+
+// returns the name of the file which the parts are merged into before verifying the signature.
+func (c *GettingConfig) mergingFileName() string {
+	return fmt.Sprintf("%s.merging", c.fileName())
+}
+
+func (c *GettingConfig) manifestFileName() string {
+	return fmt.Sprintf("%s.manifest.json", c.PartName)
+}
+
 func (config *GettingConfig) standardize() GettingConfig {
 	c := *config
 	if c.Parts <= 0 {
@@ -93,5 +199,32 @@ func (config *GettingConfig) standardize() GettingConfig {
 	if c.PartsPath == "" {
 		c.PartsPath = c.dirPath()
 	}
+	if c.ProgressInterval == 0 {
+		c.ProgressInterval = 100 * time.Millisecond
+	}
+	c.Retry = c.Retry.standardize()
 	return c
 }
+
+// standardizes the checksums (including SHA512) and returns the digests to check, including Integrity.
+func (c *GettingConfig) expectedDigests() ([]expectedDigest, error) {
+	checksums := append([]Checksum{}, c.Checksums...)
+	if c.SHA512 != "" {
+		checksums = append(checksums, Checksum{Algorithm: "sha512", Digest: c.SHA512})
+	}
+	checksums, err := standardizeChecksums(checksums)
+	if err != nil {
+		return nil, err
+	}
+	c.Checksums = checksums
+	expected := expectedDigestsOf(checksums)
+
+	if c.Integrity != "" {
+		integrity, err := parseIntegrity(c.Integrity)
+		if err != nil {
+			return nil, err
+		}
+		expected = append(expected, integrity)
+	}
+	return expected, nil
+}
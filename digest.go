@@ -0,0 +1,86 @@
+package oget
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// parses the digests of the whole file told by the server, from the headers:
+//
+//   - Digest (RFC 3230), e.g. `SHA-256=<base64>, MD5=<base64>`.
+//   - Repr-Digest (RFC 9530), e.g. `sha-256=:<base64>:`.
+//   - Content-MD5 (RFC 1864), e.g. `<base64>`. it is ignored for a partial response.
+//   - x-goog-hash, e.g. `crc32c=<base64>, md5=<base64>`.
+//
+// the digests of unknown algorithms are ignored.
+func digestsOfResponse(resp *http.Response) []Checksum {
+	if encoding := resp.Header.Get("Content-Encoding"); resp.Uncompressed || (encoding != "" && encoding != "identity") {
+		// the digests are of the encoded bytes, rather than the file saved.
+		return nil
+	}
+	digests := []Checksum{}
+	add := func(algorithm string, encoded string) {
+		algorithm = normalizeAlgorithm(algorithm)
+		if algorithm == "sha" {
+			algorithm = "sha1"
+		}
+		h, err := newHash(algorithm)
+		if err != nil {
+			return
+		}
+		digest := decodeDigest(encoded, h.Size())
+		if digest == "" {
+			return
+		}
+		checksum := Checksum{Algorithm: algorithm, Digest: digest}
+		if !slices.Contains(digests, checksum) {
+			digests = append(digests, checksum)
+		}
+	}
+	for _, name := range []string{"Digest", "X-Goog-Hash"} {
+		for _, item := range headerItems(resp.Header, name) {
+			if algorithm, value, found := strings.Cut(item, "="); found {
+				add(algorithm, value)
+			}
+		}
+	}
+	for _, item := range headerItems(resp.Header, "Repr-Digest") {
+		if algorithm, value, found := strings.Cut(item, "="); found {
+			// the value is a byte sequence of structured fields.
+			add(algorithm, strings.Trim(value, ":"))
+		}
+	}
+	if value := resp.Header.Get("Content-MD5"); value != "" && resp.StatusCode != http.StatusPartialContent {
+		add("md5", value)
+	}
+	return digests
+}
+
+// returns the items separated by commas of all values of the header.
+func headerItems(header http.Header, name string) []string {
+	items := []string{}
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// decodes the digest in base64 (or in hex sent by some servers) into lowercase hex.
+// returns empty string if the digest is invalid.
+func decodeDigest(encoded string, size int) string {
+	encoded = strings.TrimSpace(encoded)
+	if digest, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(digest) == size {
+		return hex.EncodeToString(digest)
+	}
+	if digest, err := hex.DecodeString(encoded); err == nil && len(digest) == size {
+		return hex.EncodeToString(digest)
+	}
+	return ""
+}
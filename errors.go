@@ -0,0 +1,210 @@
+package oget
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// IsRetryable reports whether the error is worth retrying.
+// errors caused by the network or the server being temporarily unavailable are retryable, including the timeouts
+// of connecting or RemoteFile.Timeout. errors caused by a wrong request, a misbehaving server, the local disk,
+// an invalid config, a mismatched checksum or the context of the caller being done are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var doneError contextDoneError
+	if errors.As(err, &doneError) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var checksumError ChecksumError
+	if errors.As(err, &checksumError) {
+		// SHA512Error wraps ChecksumError as well.
+		return false
+	}
+	var rangeNotSupportedError RangeNotSupportedError
+	if errors.As(err, &rangeNotSupportedError) {
+		return false
+	}
+	var statusError HTTPStatusError
+	if errors.As(err, &statusError) {
+		return statusError.retryable()
+	}
+	var contentRangeError ContentRangeError
+	if errors.As(err, &contentRangeError) {
+		return false
+	}
+	var contentLengthError ContentLengthError
+	if errors.As(err, &contentLengthError) {
+		return true
+	}
+	var changedError RemoteChangedError
+	if errors.As(err, &changedError) {
+		// the task must be created again to get the information of the new version.
+		return false
+	}
+	var notFoundError ChecksumNotFoundError
+	if errors.As(err, &notFoundError) {
+		return false
+	}
+	var signatureError SignatureError
+	if errors.As(err, &signatureError) {
+		return false
+	}
+	var mergeError MergeError
+	if errors.As(err, &mergeError) {
+		return false
+	}
+	var configError ConfigError
+	if errors.As(err, &configError) {
+		return false
+	}
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		// reading or writing local files (e.g. the disk is full).
+		return false
+	}
+	return true
+}
+
+// the error returned because the context of the caller is done, which is not worth retrying with the same context.
+// the timeouts set by the library (e.g. RemoteFile.Timeout) also unwrap to context.DeadlineExceeded, but are not marked.
+type contextDoneError struct {
+	err error
+}
+
+func (e contextDoneError) Error() string {
+	return e.err.Error()
+}
+
+func (e contextDoneError) Unwrap() error {
+	return e.err
+}
+
+// marks the error as contextDoneError if the context of the caller is done.
+func checkContextDone(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return contextDoneError{err: err}
+}
+
+// HTTPStatusError is the error of unexpected HTTP status code.
+type HTTPStatusError struct {
+	// the status code of the response.
+	StatusCode int
+	// the status line of the response, e.g. "404 Not Found".
+	Status string
+	// the header of the response.
+	Header http.Header
+}
+
+func (e HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected http status: %s", e.Status)
+}
+
+func (e HTTPStatusError) retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	// a successful status which is not the expected one will not change when retrying.
+	return e.StatusCode >= 500
+}
+
+func createHTTPStatusError(resp *http.Response) HTTPStatusError {
+	return HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+	}
+}
+
+// RangeNotSupportedError is the error of a server which ignores the range request and responds the whole file.
+// it unwraps to the HTTPStatusError of the response.
+type RangeNotSupportedError struct {
+	HTTPStatusError
+}
+
+func (e RangeNotSupportedError) Error() string {
+	return fmt.Sprintf("does not support range request, got http status: %s", e.Status)
+}
+
+func (e RangeNotSupportedError) Unwrap() error {
+	return e.HTTPStatusError
+}
+
+// ContentRangeError is the error of a ranged response whose Content-Range does not match the request.
+type ContentRangeError struct {
+	// the first byte requested.
+	Begin int64
+	// the last byte requested.
+	End int64
+	// the total length of the file.
+	Total int64
+	// the Content-Range header value of the response.
+	ContentRange string
+}
+
+func (e ContentRangeError) Error() string {
+	return fmt.Sprintf("unexpected content range %q, want bytes %d-%d/%d", e.ContentRange, e.Begin, e.End, e.Total)
+}
+
+// ContentLengthError is the error of a response body which ends before all expected bytes are received.
+type ContentLengthError struct {
+	// the number of bytes expected.
+	Expected int64
+	// the number of bytes received.
+	Actual int64
+}
+
+func (e ContentLengthError) Error() string {
+	return "download bytes is less than expected"
+}
+
+// RemoteChangedError is the error of the remote file changed since the parts were downloaded.
+// the validators are the ETag, or the Last-Modified if the server does not tell the ETag.
+type RemoteChangedError struct {
+	// the validator of the version which the parts belong to.
+	Expected string
+	// the validator of the current version.
+	Actual string
+}
+
+func (e RemoteChangedError) Error() string {
+	return fmt.Sprintf("remote file changed, expected version %q but got %q", e.Expected, e.Actual)
+}
+
+// MergeError is the error of moving or merging the downloaded parts into the target file.
+type MergeError struct {
+	Err error
+}
+
+func (e MergeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e MergeError) Unwrap() error {
+	return e.Err
+}
+
+func createMergeError(err error, message string) MergeError {
+	return MergeError{Err: errors.Wrap(err, message)}
+}
+
+// ConfigError is the error of an invalid GettingConfig given by the caller, e.g. a malformed checksum.
+type ConfigError struct {
+	Err error
+}
+
+func (e ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ConfigError) Unwrap() error {
+	return e.Err
+}
@@ -4,5 +4,8 @@ go 1.22.5
 
 require (
 	github.com/pkg/errors v0.9.1
+	golang.org/x/crypto v0.27.0
 	golang.org/x/sync v0.8.0
 )
+
+require golang.org/x/sys v0.25.0 // indirect
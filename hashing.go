@@ -0,0 +1,322 @@
+package oget
+
+import (
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// hashes the bytes of the parts in order with the algorithms while they are being downloaded.
+// the first unfinished part is hashed live, and a part is caught up from the disk
+// as soon as its predecessor finishes. the disk is read without the lock, so that the writers are not blocked.
+type hashChain struct {
+	mux        sync.Mutex
+	algorithms []string
+	hashes     []hash.Hash
+	// writes into all hashes.
+	output  io.Writer
+	parts   []chainPart
+	current int
+	// the number of bytes of the current part hashed.
+	hashed int64
+	// the states of the hashes at the beginning of the current part.
+	partState map[string][]byte
+	// the chain can not continue, the files will be hashed after downloading.
+	broken bool
+	// a goroutine is catching up the current part without the lock, which owns the hashes until it ends.
+	catchingUp bool
+	// the state saved when the catching up began, to be saved in the manifest meanwhile.
+	catchUpState *ManifestHash
+}
+
+type chainPart struct {
+	path string
+	// the offset of the first byte of the part in the file.
+	fileOffset int64
+	// the length of the part. the value is -1 if the length is unknown.
+	length int64
+	// the number of bytes saved from the first byte of the part.
+	written int64
+}
+
+func newHashChain(c *GettingConfig, manifest *Manifest, algorithms []string) (*hashChain, error) {
+	chain := &hashChain{
+		algorithms: algorithms,
+		hashes:     []hash.Hash{},
+		parts:      []chainPart{},
+	}
+	writers := []io.Writer{}
+	for _, algorithm := range chain.algorithms {
+		h, err := newHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		chain.hashes = append(chain.hashes, h)
+		writers = append(writers, h)
+	}
+	chain.output = io.MultiWriter(writers...)
+
+	for _, part := range manifest.Parts {
+		fileOffset := int64(0)
+		if manifest.Preallocated {
+			fileOffset = part.Begin
+		}
+		length := int64(-1)
+		if part.End >= 0 {
+			length = part.End - part.Begin + 1
+		}
+		chain.parts = append(chain.parts, chainPart{
+			path:       filepath.Join(c.PartsPath, part.File),
+			fileOffset: fileOffset,
+			length:     length,
+			written:    part.Completed,
+		})
+	}
+	chain.restore(manifest)
+	if chain.hashed == 0 {
+		chain.partState = chain.marshal()
+	}
+
+	chain.mux.Lock()
+	defer chain.mux.Unlock()
+	chain.advance()
+
+	return chain, nil
+}
+
+// restores the state saved in the manifest, so that the resumed bytes need not be hashed again.
+func (c *hashChain) restore(manifest *Manifest) {
+	state := manifest.Hash
+	if state == nil || state.Part < 0 || state.Part >= len(c.parts) {
+		return
+	}
+	for i := 0; i < state.Part; i++ {
+		if c.parts[i].length < 0 || c.parts[i].written != c.parts[i].length {
+			return
+		}
+	}
+	if state.Offset < 0 || state.Offset > c.parts[state.Part].written {
+		return
+	}
+	if !c.unmarshal(state.States) {
+		for _, h := range c.hashes {
+			h.Reset()
+		}
+		return
+	}
+	c.current = state.Part
+	c.hashed = state.Offset
+}
+
+// returns the state to save in the manifest. returns nil if the state can not be saved.
+func (c *hashChain) snapshot() *ManifestHash {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.broken || c.current >= len(c.parts) {
+		return nil
+	}
+	if c.catchingUp {
+		return c.catchUpState
+	}
+	state := c.marshal()
+	if state == nil {
+		return nil
+	}
+	return &ManifestHash{
+		Part:   c.current,
+		Offset: c.hashed,
+		States: state,
+	}
+}
+
+// returns the states of all hashes. returns nil if any of them can not be saved.
+func (c *hashChain) marshal() map[string][]byte {
+	states := map[string][]byte{}
+	for i, h := range c.hashes {
+		marshaler, ok := h.(encoding.BinaryMarshaler)
+		if !ok {
+			return nil
+		}
+		state, err := marshaler.MarshalBinary()
+		if err != nil {
+			return nil
+		}
+		states[c.algorithms[i]] = state
+	}
+	return states
+}
+
+// restores the states of all hashes. returns false if any of them can not be restored.
+func (c *hashChain) unmarshal(states map[string][]byte) bool {
+	for i, h := range c.hashes {
+		unmarshaler, ok := h.(encoding.BinaryUnmarshaler)
+		state, found := states[c.algorithms[i]]
+		if !ok || !found || unmarshaler.UnmarshalBinary(state) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the writer which saves the bytes of the part from the offset (from the first byte of the part).
+func (c *hashChain) writer(index int, offset int64, output io.Writer) io.Writer {
+	return &chainWriter{chain: c, index: index, offset: offset, output: output}
+}
+
+func (c *hashChain) wrote(index int, offset int64, p []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	part := &c.parts[index]
+	end := offset + int64(len(p))
+	part.written = end
+
+	if c.broken || index != c.current || c.catchingUp {
+		// the goroutine catching up will read the bytes from the disk.
+		return
+	}
+	if offset <= c.hashed && end > c.hashed {
+		c.output.Write(p[c.hashed-offset:])
+		c.hashed = end
+	}
+	c.advance()
+}
+
+// marks the part finished, so that the chain knows the length of a part with unknown length.
+func (c *hashChain) finish(index int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	part := &c.parts[index]
+	if part.length < 0 {
+		part.length = part.written
+	}
+	c.advance()
+}
+
+// forgets the bytes of the part, because it will be downloaded from the beginning again.
+func (c *hashChain) reset(index int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.parts[index].written = 0
+	if c.broken || index != c.current {
+		return
+	}
+	if c.catchingUp {
+		// the bytes being hashed are stale, and the hashes can not be restored meanwhile.
+		c.broken = true
+		return
+	}
+	if c.hashed == 0 {
+		return
+	}
+	if c.partState != nil && c.unmarshal(c.partState) {
+		c.hashed = 0
+	} else {
+		c.broken = true
+	}
+}
+
+// stops the chain because the bytes hashed are overwritten. the files will be hashed after downloading.
+func (c *hashChain) invalidate() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.broken = true
+}
+
+// returns the digests in hex of all parts by their algorithms.
+// returns nil if the chain did not hash all of them.
+func (c *hashChain) sum() map[string]string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.broken || c.current < len(c.parts) {
+		return nil
+	}
+	digests := map[string]string{}
+	for i, h := range c.hashes {
+		digests[c.algorithms[i]] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return digests
+}
+
+// moves to the next part when the current one is hashed entirely, and catches up the bytes saved.
+// it must be called with the lock held, which is released while reading the disk.
+func (c *hashChain) advance() {
+	if c.catchingUp {
+		// the goroutine catching up will advance once it ends.
+		return
+	}
+	for !c.broken && c.current < len(c.parts) {
+		part := c.parts[c.current]
+		if c.hashed < part.written {
+			if !c.catchUp(part) {
+				return
+			}
+			// more bytes may be saved while catching up.
+			continue
+		}
+		if part.length < 0 || c.hashed < part.length {
+			return
+		}
+		c.current += 1
+		c.hashed = 0
+		c.partState = c.marshal()
+	}
+}
+
+// hashes the bytes of the part saved but not hashed yet from the disk.
+// the lock is released while reading, and the hashes are only written by this goroutine meanwhile.
+// returns false if the chain is broken.
+func (c *hashChain) catchUp(part chainPart) bool {
+	offset, length := c.hashed, part.written-c.hashed
+	c.catchUpState = nil
+	if state := c.marshal(); state != nil {
+		c.catchUpState = &ManifestHash{Part: c.current, Offset: offset, States: state}
+	}
+	c.catchingUp = true
+	c.mux.Unlock()
+
+	written, err := hashSection(c.output, part.path, part.fileOffset+offset, length)
+
+	c.mux.Lock()
+	c.catchingUp = false
+	c.catchUpState = nil
+	c.hashed += written
+
+	if err != nil {
+		c.broken = true
+	}
+	return !c.broken
+}
+
+func hashSection(output io.Writer, path string, offset int64, length int64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return io.Copy(output, io.NewSectionReader(file, offset, length))
+}
+
+type chainWriter struct {
+	chain  *hashChain
+	index  int
+	offset int64
+	output io.Writer
+}
+
+func (w *chainWriter) Write(p []byte) (int, error) {
+	n, err := w.output.Write(p)
+	if n > 0 {
+		w.chain.wrote(w.index, w.offset, p[:n])
+		w.offset += int64(n)
+	}
+	return n, err
+}
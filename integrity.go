@@ -0,0 +1,78 @@
+package oget
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// the algorithms of Subresource Integrity from the weakest to the strongest.
+var integrityAlgorithms = []string{"sha256", "sha384", "sha512"}
+
+// parses the SRI string (e.g. "sha512-<base64>"), which contains one or more tokens separated by spaces.
+// only the tokens of the strongest algorithm are used, and the file matches if it matches any of them.
+// the tokens of unknown algorithms and the options after "?" are ignored.
+func parseIntegrity(integrity string) (expectedDigest, error) {
+	strongest := -1
+	digests := []string{}
+
+	for _, token := range strings.Fields(integrity) {
+		token, _, _ = strings.Cut(token, "?")
+		algorithm, encoded, found := strings.Cut(token, "-")
+		index := slices.Index(integrityAlgorithms, strings.ToLower(algorithm))
+
+		if !found || index < 0 || index < strongest {
+			continue
+		}
+		digest, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return expectedDigest{}, errors.Errorf("invalid integrity token %q", token)
+		}
+		h, _ := newHash(integrityAlgorithms[index])
+		if len(digest) != h.Size() {
+			return expectedDigest{}, errors.Errorf("invalid integrity token %q", token)
+		}
+		if index > strongest {
+			strongest = index
+			digests = []string{}
+		}
+		digests = append(digests, hex.EncodeToString(digest))
+	}
+	if strongest < 0 {
+		return expectedDigest{}, errors.Errorf("no supported algorithm in integrity %q", integrity)
+	}
+	return expectedDigest{
+		algorithm: integrityAlgorithms[strongest],
+		digests:   digests,
+	}, nil
+}
+
+// returns the SRI string (e.g. "sha512-<base64>") of the file.
+// the algorithms can be sha256, sha384 and sha512. the default is sha512.
+// if several algorithms are given, the tokens are separated by spaces.
+func SRI(path string, algorithms ...string) (string, error) {
+	if len(algorithms) == 0 {
+		algorithms = []string{"sha512"}
+	}
+	names := []string{}
+	for _, algorithm := range algorithms {
+		name := normalizeAlgorithm(algorithm)
+		if !slices.Contains(integrityAlgorithms, name) {
+			return "", errors.Errorf("unsupported integrity algorithm %q", algorithm)
+		}
+		names = append(names, name)
+	}
+	digests, err := hashFiles([]string{path}, names, nil)
+	if err != nil {
+		return "", err
+	}
+	tokens := []string{}
+	for _, algorithm := range names {
+		digest, _ := hex.DecodeString(digests[algorithm])
+		tokens = append(tokens, algorithm+"-"+base64.StdEncoding.EncodeToString(digest))
+	}
+	return strings.Join(tokens, " "), nil
+}
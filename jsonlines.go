@@ -0,0 +1,286 @@
+package oget
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JSONSchemaVersion is the version of the records written by JSONLinesEncoder.
+// it is increased only if a field is removed or its meaning is changed. new fields may be added to the same version.
+const JSONSchemaVersion = 1
+
+const (
+	// JSONRecordProgress is the type of JSONProgressRecord.
+	JSONRecordProgress = "progress"
+	// JSONRecordResult is the type of JSONResultRecord.
+	JSONRecordResult = "result"
+)
+
+// JSONProgressRecord is the line written for a ProgressEvent.
+type JSONProgressRecord struct {
+	// the value is JSONSchemaVersion.
+	Schema int `json:"schema"`
+	// the value is JSONRecordProgress.
+	Type string `json:"type"`
+	// the time of the event in RFC 3339 with nanoseconds.
+	Time time.Time `json:"time"`
+	// one of "downloading", "verifying", "copying" and "done".
+	Phase string `json:"phase"`
+	// the bytes of the phase completed.
+	Progress int64 `json:"progress"`
+	// the total bytes of the phase. the value is -1 if the length is unknown.
+	Total int64 `json:"total"`
+	// the bytes saved by the previous attempts, which are counted in progress.
+	Resumed int64 `json:"resumed"`
+	// the bytes per second in the last few seconds.
+	Speed float64 `json:"speed"`
+	// the bytes per second since the phase began, excluding the resumed bytes.
+	AverageSpeed float64 `json:"averageSpeed"`
+	// the estimated seconds to complete the phase. the field is omitted if it can not be estimated.
+	ETA *float64 `json:"eta,omitempty"`
+	// the number of the retries of all parts so far.
+	Retries int `json:"retries"`
+	// whether the downloading is paused.
+	Paused bool `json:"paused"`
+	// the index of the part which the bytes belong to. the field is omitted if the event does not belong to a part.
+	Part *int `json:"part,omitempty"`
+	// the offset of the first byte of the part in the file. the field is omitted with part.
+	PartOffset *int64 `json:"partOffset,omitempty"`
+	// the length of the part, or -1 if unknown. the field is omitted with part.
+	PartLength *int64 `json:"partLength,omitempty"`
+	// the bytes of the part completed. the field is omitted with part.
+	PartProgress *int64 `json:"partProgress,omitempty"`
+}
+
+// JSONResultRecord is the last line written for the result of a download.
+type JSONResultRecord struct {
+	// the value is JSONSchemaVersion.
+	Schema int `json:"schema"`
+	// the value is JSONRecordResult.
+	Type string `json:"type"`
+	// the time of the result in RFC 3339 with nanoseconds.
+	Time time.Time `json:"time"`
+	// whether the file is downloaded.
+	OK bool `json:"ok"`
+	// the path of the file. the field is omitted if the path is not known yet.
+	Path string `json:"path,omitempty"`
+	// the URL of the file after redirects. the field is omitted if the file is not probed.
+	URL string `json:"url,omitempty"`
+	// the bytes downloaded in this run, excluding the resumed ones.
+	Downloaded int64 `json:"downloaded"`
+	// the bytes saved by the previous runs, which are not downloaded again.
+	Resumed int64 `json:"resumed"`
+	// the seconds of the phases.
+	Durations JSONDurationsRecord `json:"durations"`
+	// the bytes per second of downloaded during the downloading.
+	AverageSpeed float64 `json:"averageSpeed"`
+	// the number of the retries of all parts.
+	Retries int `json:"retries"`
+	// the digests of the file in hex by algorithm, which have been verified.
+	// the field is omitted if no checksum is verified.
+	Digests map[string]string `json:"digests,omitempty"`
+	// the error of the download. the field is omitted if the download succeeded.
+	Error *JSONErrorRecord `json:"error,omitempty"`
+}
+
+// JSONDurationsRecord is the seconds of the phases of a download, see Result.
+type JSONDurationsRecord struct {
+	Probe    float64 `json:"probe"`
+	Download float64 `json:"download"`
+	Verify   float64 `json:"verify"`
+	Merge    float64 `json:"merge"`
+}
+
+// JSONErrorRecord describes the error of a download.
+type JSONErrorRecord struct {
+	// the kind of the error, see ErrorKind.
+	Kind ErrorKind `json:"kind"`
+	// the message of the error, which is not stable and should not be parsed.
+	Message string `json:"message"`
+	// whether the error is worth retrying, see IsRetryable.
+	Retryable bool `json:"retryable"`
+	// the HTTP status code of the response. the field is omitted if the error is not caused by the status.
+	StatusCode int `json:"statusCode,omitempty"`
+}
+
+// ErrorKind is the stable name of the type of an error.
+type ErrorKind string
+
+const (
+	ErrorKindCanceled          ErrorKind = "canceled"
+	ErrorKindTimeout           ErrorKind = "timeout"
+	ErrorKindChecksum          ErrorKind = "checksum"
+	ErrorKindChecksumNotFound  ErrorKind = "checksum_not_found"
+	ErrorKindSignature         ErrorKind = "signature"
+	ErrorKindRangeNotSupported ErrorKind = "range_not_supported"
+	ErrorKindHTTPStatus        ErrorKind = "http_status"
+	ErrorKindContentRange      ErrorKind = "content_range"
+	ErrorKindContentLength     ErrorKind = "content_length"
+	ErrorKindRemoteChanged     ErrorKind = "remote_changed"
+	ErrorKindMirrorMismatch    ErrorKind = "mirror_mismatch"
+	ErrorKindStall             ErrorKind = "stall"
+	// the config given by the caller is invalid, e.g. a malformed checksum.
+	ErrorKindConfig ErrorKind = "config"
+	// the local files can not be read or written, e.g. the disk is full.
+	ErrorKindFilesystem ErrorKind = "filesystem"
+	// the other errors, e.g. the server is unreachable.
+	ErrorKindNetwork ErrorKind = "network"
+)
+
+// returns the kind of the error by its type. returns empty string if the error is nil.
+func ErrorKindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorKindCanceled
+	}
+	var netError net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netError) && netError.Timeout()) {
+		return ErrorKindTimeout
+	}
+	kinds := []struct {
+		target any
+		kind   ErrorKind
+	}{
+		{&ChecksumError{}, ErrorKindChecksum},
+		{&ChecksumNotFoundError{}, ErrorKindChecksumNotFound},
+		{&SignatureError{}, ErrorKindSignature},
+		// RangeNotSupportedError unwraps to HTTPStatusError.
+		{&RangeNotSupportedError{}, ErrorKindRangeNotSupported},
+		{&HTTPStatusError{}, ErrorKindHTTPStatus},
+		{&ContentRangeError{}, ErrorKindContentRange},
+		{&ContentLengthError{}, ErrorKindContentLength},
+		{&RemoteChangedError{}, ErrorKindRemoteChanged},
+		{&MirrorMismatchError{}, ErrorKindMirrorMismatch},
+		{&StallError{}, ErrorKindStall},
+		{&ConfigError{}, ErrorKindConfig},
+		{&MergeError{}, ErrorKindFilesystem},
+		{new(*fs.PathError), ErrorKindFilesystem},
+	}
+	for _, k := range kinds {
+		if errors.As(err, k.target) {
+			return k.kind
+		}
+	}
+	return ErrorKindNetwork
+}
+
+// JSONLinesEncoder writes the progress and the result of a download as JSON lines,
+// i.e. one JSONProgressRecord or JSONResultRecord per line.
+type JSONLinesEncoder struct {
+	mux     sync.Mutex
+	encoder *json.Encoder
+	// the first error of writing, the later records are not written.
+	err error
+}
+
+func NewJSONLinesEncoder(writer io.Writer) *JSONLinesEncoder {
+	return &JSONLinesEncoder{encoder: json.NewEncoder(writer)}
+}
+
+// writes the event. it can be used as ProgressListener.
+func (e *JSONLinesEncoder) Listen(event ProgressEvent) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	record := JSONProgressRecord{
+		Schema:       JSONSchemaVersion,
+		Type:         JSONRecordProgress,
+		Time:         time.Now(),
+		Phase:        phaseNames[event.Phase],
+		Progress:     event.Progress,
+		Total:        event.Total,
+		Resumed:      event.Resumed,
+		Speed:        event.Speed,
+		AverageSpeed: event.AverageSpeed,
+		Retries:      event.Retries,
+		Paused:       event.Paused,
+	}
+	if event.ETA >= 0 {
+		eta := event.ETA.Seconds()
+		record.ETA = &eta
+	}
+	if event.Part >= 0 && event.Phase == ProgressPhaseDownloading {
+		part, offset, length, progress := event.Part, event.PartOffset, event.PartLength, event.PartProgress
+		record.Part = &part
+		record.PartOffset = &offset
+		record.PartLength = &length
+		record.PartProgress = &progress
+	}
+	e.encode(record)
+}
+
+// writes the result of the download. the download succeeded if err is nil.
+// the result is nil if the download fails before Get, e.g. the file can not be probed.
+func (e *JSONLinesEncoder) EncodeResult(result *Result, err error) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	record := JSONResultRecord{
+		Schema: JSONSchemaVersion,
+		Type:   JSONRecordResult,
+		Time:   time.Now(),
+		OK:     err == nil,
+	}
+	if result != nil {
+		record.Path = result.FilePath
+		record.URL = result.FinalURL
+		record.Downloaded = result.Downloaded
+		record.Resumed = result.Resumed
+		record.Durations = JSONDurationsRecord{
+			Probe:    result.ProbeDuration.Seconds(),
+			Download: result.DownloadDuration.Seconds(),
+			Verify:   result.VerifyDuration.Seconds(),
+			Merge:    result.MergeDuration.Seconds(),
+		}
+		record.AverageSpeed = result.AverageSpeed
+		record.Retries = result.Retries
+		for _, digest := range result.Digests {
+			if record.Digests == nil {
+				record.Digests = map[string]string{}
+			}
+			record.Digests[digest.Algorithm] = digest.Digest
+		}
+	}
+	if err != nil {
+		record.Error = &JSONErrorRecord{
+			Kind:      ErrorKindOf(err),
+			Message:   err.Error(),
+			Retryable: IsRetryable(err),
+		}
+		var statusError HTTPStatusError
+		if errors.As(err, &statusError) {
+			record.Error.StatusCode = statusError.StatusCode
+		}
+	}
+	e.encode(record)
+	return e.err
+}
+
+// returns the first error of writing.
+func (e *JSONLinesEncoder) Err() error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.err
+}
+
+func (e *JSONLinesEncoder) encode(record any) {
+	if e.err == nil {
+		e.err = e.encoder.Encode(record)
+	}
+}
+
+var phaseNames = map[ProgressPhase]string{
+	ProgressPhaseDownloading: "downloading",
+	ProgressPhaseVerifying:   "verifying",
+	ProgressPhaseCoping:      "copying",
+	ProgressPhaseDone:        "done",
+}
@@ -0,0 +1,430 @@
+package oget
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ManagerConfig is the configuration of a Manager.
+type ManagerConfig struct {
+	// the context of the manager. all jobs are canceled once it is done.
+	// if the value is nil, the context will be context.Background().
+	Context context.Context
+	// the maximum number of jobs downloading at the same time.
+	// the default is 4.
+	Workers int
+	// the maximum number of connections (the parts of the jobs) downloading at the same time.
+	// a job whose Parts exceed it is downloaded with fewer parts.
+	// if the value is less than or equal to 0, the connections are only limited by Workers.
+	MaxConnections int
+	// the maximum number of connections downloading from a host at the same time.
+	// a job whose Parts exceed it is downloaded with fewer parts.
+	// if the value is less than or equal to 0, the connections of a host are not limited.
+	MaxConnectionsPerHost int
+	// the maximum number of idle (keep-alive) connections to keep per-host, shared by all jobs.
+	// the default is 16.
+	MaxIdleConnsPerHost int
+	// the listener of the progress of all jobs.
+	// it is called one at a time, and must not wait for the jobs.
+	// if the value is nil, the progress will not be listened.
+	ListenProgress ManagerProgressListener
+}
+
+// JobState is the state of a job of a Manager.
+type JobState int
+
+const (
+	// JobPending is the state of a job waiting for a worker.
+	JobPending JobState = iota
+	// JobRunning is the state of a job downloading.
+	JobRunning
+	// JobSucceeded is the state of a job downloaded.
+	JobSucceeded
+	// JobFailed is the state of a job failed. its parts are kept to resume.
+	JobFailed
+	// JobCanceled is the state of a job canceled, by Job.Cancel, Manager.Close or the contexts.
+	JobCanceled
+)
+
+// ManagerProgressListener is the listener of the progress of a Manager.
+type ManagerProgressListener func(event ManagerProgressEvent)
+
+// ManagerProgressEvent is fired when a job changes its state or progress.
+type ManagerProgressEvent struct {
+	// the job which fires the event.
+	Job *Job
+	// the state of the job.
+	State JobState
+	// the last progress event of the job. the value is zero if the job has not started downloading.
+	Event ProgressEvent
+	// the number of the jobs of each state.
+	Pending   int
+	Running   int
+	Succeeded int
+	Failed    int
+	Canceled  int
+	// the bytes downloaded by all jobs.
+	Progress int64
+	// the total length of the jobs whose length is known.
+	Total int64
+}
+
+// JobResult is the result of a job of a Manager.
+type JobResult struct {
+	Job *Job
+	// the error of the job. the value is nil if the job succeeded.
+	Err error
+	// the result of OGet.Get, whose Clean removes the temp files of the job.
+	// the value is never nil, and only FilePath is set if the job has not started.
+	Result *Result
+}
+
+// Manager downloads many files with a bounded number of workers and connections.
+// the jobs share one http.Client, and can be added or canceled while the manager is running.
+type Manager struct {
+	config    ManagerConfig
+	context   context.Context
+	cancel    context.CancelFunc
+	client    *http.Client
+	wg        sync.WaitGroup
+	mux       sync.Mutex
+	jobs      []*Job
+	queue     []*Job
+	running   int
+	conns     int
+	hostConns map[string]int
+	closed    bool
+	// serializes the listener, and guards the progress.
+	listenMux sync.Mutex
+	progress  int64
+	total     int64
+}
+
+// Job is a download of a Manager.
+type Job struct {
+	id      int
+	config  OGet
+	host    string
+	conns   int
+	manager *Manager
+	context context.Context
+	cancel  context.CancelFunc
+	stop    func() bool
+	done    chan struct{}
+	// guarded by Manager.mux.
+	state  JobState
+	result JobResult
+	// guarded by Manager.listenMux.
+	event    ProgressEvent
+	progress int64
+	total    int64
+}
+
+func (config *ManagerConfig) standardize() ManagerConfig {
+	c := *config
+	if c.Context == nil {
+		c.Context = context.Background()
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = 16
+	}
+	return c
+}
+
+// creates a Manager. it runs until Close is called or its context is done.
+func NewManager(config *ManagerConfig) *Manager {
+	c := config.standardize()
+	ctx, cancel := context.WithCancel(c.Context)
+	return &Manager{
+		config:    c,
+		context:   ctx,
+		cancel:    cancel,
+		client:    newGettingClient(c.MaxIdleConnsPerHost),
+		hostConns: map[string]int{},
+	}
+}
+
+// adds a job to download the file. the job starts once a worker and its connections are available.
+// the Client of the config is replaced by the one of the manager.
+// if the manager is closed, the job is canceled at once.
+func (m *Manager) Add(config *OGet) *Job {
+	job := &Job{
+		config:  *config,
+		conns:   max(1, config.Parts),
+		manager: m,
+		done:    make(chan struct{}),
+		total:   -1,
+	}
+	if u, err := url.Parse(config.URL); err == nil {
+		job.host = u.Host
+	}
+	if m.config.MaxConnections > 0 {
+		job.conns = min(job.conns, m.config.MaxConnections)
+	}
+	if m.config.MaxConnectionsPerHost > 0 {
+		job.conns = min(job.conns, m.config.MaxConnectionsPerHost)
+	}
+	job.context, job.cancel = context.WithCancel(m.context)
+	job.stop = func() bool { return false }
+	if config.Context != nil {
+		job.stop = context.AfterFunc(config.Context, job.cancel)
+	}
+	m.mux.Lock()
+	job.id = len(m.jobs) + 1
+	m.jobs = append(m.jobs, job)
+	closed := m.closed
+	if !closed {
+		m.queue = append(m.queue, job)
+	}
+	m.mux.Unlock()
+
+	if closed {
+		m.finish(job, nil, errors.New("the manager is closed"))
+	} else {
+		m.fireProgress(job, nil)
+		m.schedule()
+	}
+	return job
+}
+
+// returns the jobs in the order they were added.
+func (m *Manager) Jobs() []*Job {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]*Job{}, m.jobs...)
+}
+
+// waits for all jobs, including the ones added while waiting, and returns their results in the order they were added.
+func (m *Manager) Wait() []JobResult {
+	for {
+		jobs := m.Jobs()
+		for _, job := range jobs {
+			<-job.done
+		}
+		m.mux.Lock()
+		count := len(m.jobs)
+		m.mux.Unlock()
+
+		if count == len(jobs) {
+			results := []JobResult{}
+			for _, job := range jobs {
+				results = append(results, job.Result())
+			}
+			return results
+		}
+	}
+}
+
+// cancels all jobs, and waits for the running ones to stop. jobs added later are canceled at once.
+func (m *Manager) Close() {
+	m.mux.Lock()
+	m.closed = true
+	queue := m.queue
+	m.queue = nil
+	m.mux.Unlock()
+
+	m.cancel()
+	for _, job := range queue {
+		m.finish(job, nil, context.Canceled)
+	}
+	m.wg.Wait()
+}
+
+// starts the pending jobs which fit the workers and the connections, in the order they were added.
+func (m *Manager) schedule() {
+	started := []*Job{}
+	m.mux.Lock()
+	queue := []*Job{}
+
+	for _, job := range m.queue {
+		if m.closed || m.running >= m.config.Workers || !m.fits(job) {
+			queue = append(queue, job)
+			continue
+		}
+		m.running += 1
+		m.conns += job.conns
+		m.hostConns[job.host] += job.conns
+		job.state = JobRunning
+		started = append(started, job)
+	}
+	m.queue = queue
+	m.wg.Add(len(started))
+	m.mux.Unlock()
+
+	for _, job := range started {
+		go m.run(job)
+	}
+}
+
+func (m *Manager) fits(job *Job) bool {
+	if m.config.MaxConnections > 0 && m.conns+job.conns > m.config.MaxConnections {
+		return false
+	}
+	if m.config.MaxConnectionsPerHost > 0 && m.hostConns[job.host]+job.conns > m.config.MaxConnectionsPerHost {
+		return false
+	}
+	return true
+}
+
+func (m *Manager) run(job *Job) {
+	defer m.wg.Done()
+	m.fireProgress(job, nil)
+
+	o := job.config
+	o.Context = job.context
+	o.Client = m.client
+	o.Parts = min(max(1, o.Parts), job.conns)
+	listen := o.ListenProgress
+	o.ListenProgress = func(event ProgressEvent) {
+		if listen != nil {
+			listen(event)
+		}
+		m.fireProgress(job, &event)
+	}
+	result, err := o.Get()
+	m.finish(job, result, err)
+}
+
+// finishes the running or pending job, and starts the next ones.
+func (m *Manager) finish(job *Job, result *Result, err error) {
+	if result == nil {
+		result = &Result{FilePath: job.config.FilePath}
+	}
+	state := JobSucceeded
+	if err != nil {
+		state = JobFailed
+		if job.context.Err() != nil {
+			state = JobCanceled
+		}
+	}
+	m.mux.Lock()
+	if job.state == JobRunning {
+		m.running -= 1
+		m.conns -= job.conns
+		m.hostConns[job.host] -= job.conns
+	}
+	job.state = state
+	job.result = JobResult{Job: job, Err: err, Result: result}
+	m.mux.Unlock()
+
+	job.stop()
+	job.cancel()
+	m.fireProgress(job, nil)
+	close(job.done)
+	m.schedule()
+}
+
+// removes the job from the queue. returns false if the job is not pending.
+func (m *Manager) dequeue(job *Job) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for i, pending := range m.queue {
+		if pending == job {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fires the event of the job. the event is nil if only the state of the job changes.
+func (m *Manager) fireProgress(job *Job, event *ProgressEvent) {
+	m.listenMux.Lock()
+	defer m.listenMux.Unlock()
+
+	if event != nil {
+		job.event = *event
+		progress, total := job.progress, job.total
+
+		switch event.Phase {
+		case ProgressPhaseDownloading:
+			progress = event.Progress
+			if event.Total >= 0 {
+				total = event.Total
+			}
+		default:
+			// the length is known once the downloading is done.
+			progress = event.Total
+			total = event.Total
+		}
+		m.progress += progress - job.progress
+		m.total += max(total, 0) - max(job.total, 0)
+		job.progress, job.total = progress, total
+	}
+	if m.config.ListenProgress == nil {
+		return
+	}
+	e := ManagerProgressEvent{
+		Job:      job,
+		Event:    job.event,
+		Progress: m.progress,
+		Total:    m.total,
+	}
+	m.mux.Lock()
+	e.State = job.state
+	for _, j := range m.jobs {
+		switch j.state {
+		case JobPending:
+			e.Pending += 1
+		case JobRunning:
+			e.Running += 1
+		case JobSucceeded:
+			e.Succeeded += 1
+		case JobFailed:
+			e.Failed += 1
+		case JobCanceled:
+			e.Canceled += 1
+		}
+	}
+	m.mux.Unlock()
+	m.config.ListenProgress(e)
+}
+
+// returns the ID of the job, which starts from 1 in the order the jobs were added.
+func (j *Job) ID() int {
+	return j.id
+}
+
+// returns the config of the job.
+func (j *Job) Config() OGet {
+	return j.config
+}
+
+func (j *Job) State() JobState {
+	j.manager.mux.Lock()
+	defer j.manager.mux.Unlock()
+	return j.state
+}
+
+// cancels the job. a pending job is canceled at once, and a running job stops downloading soon.
+func (j *Job) Cancel() {
+	j.cancel()
+	if j.manager.dequeue(j) {
+		j.manager.finish(j, nil, context.Canceled)
+	}
+}
+
+// returns the channel closed once the job is finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// waits for the job and returns its result.
+func (j *Job) Wait() JobResult {
+	<-j.done
+	return j.Result()
+}
+
+// returns the result of the job. the result is zero until the job is finished.
+func (j *Job) Result() JobResult {
+	j.manager.mux.Lock()
+	defer j.manager.mux.Unlock()
+	return j.result
+}
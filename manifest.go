@@ -0,0 +1,453 @@
+package oget
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const manifestVersion = 1
+
+// Manifest describes an in-progress download. it is saved as a JSON file next to the part files,
+// and is loaded to validate the part files before resuming.
+type Manifest struct {
+	// the version of the manifest format.
+	Version int `json:"version"`
+	// the URL of the file to download.
+	URL string `json:"url"`
+	// the URL after redirects.
+	FinalURL string `json:"finalUrl,omitempty"`
+	// the content length of the file. the value is -1 if the length is unknown.
+	ContentLength int64 `json:"contentLength"`
+	// the ETag header value of the file.
+	ETag string `json:"etag,omitempty"`
+	// the Last-Modified header value of the file.
+	LastModified string `json:"lastModified,omitempty"`
+	// the expected checksums of the file.
+	Checksums []Checksum `json:"checksums,omitempty"`
+	// the expected Subresource Integrity string of the file.
+	Integrity string `json:"integrity,omitempty"`
+	// whether the parts are written into one preallocated file instead of their own files.
+	Preallocated bool `json:"preallocated,omitempty"`
+	// the size of each block in the bitmaps of completed blocks, only for the preallocated file.
+	BlockSize int64 `json:"blockSize,omitempty"`
+	// the layout of the parts.
+	Parts []ManifestPart `json:"parts"`
+	// the state of the hash computed while downloading.
+	Hash *ManifestHash `json:"hash,omitempty"`
+}
+
+// ManifestPart describes a part of an in-progress download.
+type ManifestPart struct {
+	// the name of the part file, relative to the directory of the manifest.
+	File string `json:"file"`
+	// the first byte of the part in the file.
+	Begin int64 `json:"begin"`
+	// the last byte of the part in the file. the value is -1 if the length is unknown.
+	End int64 `json:"end"`
+	// the number of bytes saved in the part file.
+	Completed int64 `json:"completed"`
+	// the bitmap of completed blocks from the first byte of the part, only for the preallocated file.
+	Blocks []byte `json:"blocks,omitempty"`
+}
+
+// ManifestHash is the state of the hashes computed while downloading,
+// so that a resumed download need not hash the saved bytes again.
+type ManifestHash struct {
+	// the index of the part being hashed.
+	Part int `json:"part"`
+	// the number of bytes of the part hashed.
+	Offset int64 `json:"offset"`
+	// the binary states of the hashes by their algorithms.
+	States map[string][]byte `json:"states"`
+}
+
+// LoadManifest loads the manifest from the path.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "invalid manifest")
+	}
+	if manifest.Version != manifestVersion {
+		return nil, errors.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// RemoveManifest removes the part files recorded in the manifest and the manifest itself.
+// only the part files named after the manifest are removed, the others recorded are ignored.
+func RemoveManifest(path string) error {
+	manifest, err := LoadManifest(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	dirPath, fileName := filepath.Split(path)
+	layout := GettingConfig{
+		PartName: strings.TrimSuffix(fileName, ".manifest.json"),
+		Parts:    len(manifest.Parts),
+	}
+	if manifest.Preallocated {
+		layout.Storage = StoragePreallocated
+	}
+	for i, part := range manifest.Parts {
+		// the manifest is not trusted, whose files may be out of its directory.
+		if part.File != layout.storageFileName(i) {
+			continue
+		}
+		if err := removeIfExists(filepath.Join(dirPath, part.File)); err != nil {
+			return err
+		}
+	}
+	return removeIfExists(path)
+}
+
+func (m *Manifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	// writes to a temp file first, so that a crash never leaves a broken manifest.
+	tempPath := path + ".tmp"
+	if err := writeFileSync(tempPath, data); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+	return nil
+}
+
+// writes the file and flushes it to the disk.
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// the manifest shared by the parts of a downloading.
+type manifestFile struct {
+	mux      sync.Mutex
+	path     string
+	manifest *Manifest
+	// hashes the parts while downloading. the value is nil if no checksum is required.
+	chain *hashChain
+	// verifies the chunks of the parts. the value is nil if no chunks are given.
+	chunks *chunkVerifier
+}
+
+func (f *manifestFile) save() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.saveLocked()
+}
+
+func (f *manifestFile) saveLocked() error {
+	if f.chain != nil {
+		f.manifest.Hash = f.chain.snapshot()
+	}
+	return f.manifest.save(f.path)
+}
+
+// records the bytes [begin, end) of the part saved by an attempt, from the first byte of the part.
+// the bytes must have been flushed to the disk if the parts are preallocated.
+func (f *manifestFile) updatePart(index int, begin int64, end int64) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	part := &f.manifest.Parts[index]
+
+	if f.manifest.Preallocated {
+		if begin <= part.Completed {
+			// continues the completed bytes, whose last block may have been partial.
+			begin = 0
+		}
+		part.markBlocks(begin, end, f.manifest.BlockSize)
+	}
+	part.Completed = end
+	return f.saveLocked()
+}
+
+func (f *manifestFile) part(index int) ManifestPart {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.manifest.Parts[index]
+}
+
+// returns whether the bytes [begin, end] of the file are all saved.
+func (f *manifestFile) saved(begin int64, end int64) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for _, part := range f.manifest.Parts {
+		if max(begin, part.Begin) > min(end, part.End) {
+			continue
+		}
+		if part.Begin+part.Completed <= min(end, part.End) {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the files of the parts in order, without duplicates.
+func (f *manifestFile) partFiles() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	files := []string{}
+	for _, part := range f.manifest.Parts {
+		if len(files) == 0 || files[len(files)-1] != part.File {
+			files = append(files, part.File)
+		}
+	}
+	return files
+}
+
+// marks the blocks covered by the bytes [begin, end) from the first byte of the part.
+// the blocks marked before are kept.
+func (p *ManifestPart) markBlocks(begin int64, end int64, blockSize int64) {
+	length := p.End - p.Begin + 1
+	count := (length + blockSize - 1) / blockSize
+	if size := int((count + 7) / 8); len(p.Blocks) < size {
+		p.Blocks = append(p.Blocks, make([]byte, size-len(p.Blocks))...)
+	}
+	for i := (begin + blockSize - 1) / blockSize; i < count; i++ {
+		if min((i+1)*blockSize, length) > end {
+			break
+		}
+		p.Blocks[i/8] |= 1 << (i % 8)
+	}
+}
+
+// returns the number of bytes covered by the leading completed blocks.
+func (p *ManifestPart) completedOfBlocks(blockSize int64) int64 {
+	length := p.End - p.Begin + 1
+	completed := int64(0)
+
+	for i := int64(0); completed < length; i++ {
+		if i/8 >= int64(len(p.Blocks)) || p.Blocks[i/8]&(1<<(i%8)) == 0 {
+			break
+		}
+		completed = min((i+1)*blockSize, length)
+	}
+	return completed
+}
+
+// the number of blocks written between the checkpoints of a part in the preallocated file.
+const checkpointBlocks = 16
+
+// writes a part into the preallocated file, and saves the blocks completed into the manifest
+// every checkpointBlocks blocks, so that a crash loses no more bytes than them.
+type checkpointWriter struct {
+	file     *os.File
+	output   io.Writer
+	manifest *manifestFile
+	index    int
+	// the offset of the first byte written, from the first byte of the part.
+	begin      int64
+	written    int64
+	checkpoint int64
+}
+
+func (w *checkpointWriter) Write(p []byte) (int, error) {
+	n, err := w.output.Write(p)
+	w.written += int64(n)
+
+	if err == nil && w.written-w.checkpoint >= checkpointBlocks*w.manifest.manifest.BlockSize {
+		// the blocks are marked only after they are flushed to the disk.
+		if err := w.file.Sync(); err != nil {
+			return n, errors.Wrap(err, "failed to flush file")
+		}
+		if err := w.manifest.updatePart(w.index, w.begin, w.begin+w.written); err != nil {
+			return n, err
+		}
+		w.checkpoint = w.written
+	}
+	return n, err
+}
+
+// returns the block size which keeps the bitmaps small.
+func blockSizeOf(contentLength int64) int64 {
+	const minBlockSize = 4 * 1024
+	const maxBlockSize = 16 * 1024 * 1024
+	return max(minBlockSize, min(maxBlockSize, (contentLength+4095)/4096))
+}
+
+func (t *GettingTask) createManifest(c *GettingConfig) *Manifest {
+	manifest := &Manifest{
+		Version:       manifestVersion,
+		URL:           t.url,
+		FinalURL:      t.finalURL,
+		ContentLength: t.contentLength,
+		ETag:          t.etag,
+		LastModified:  t.lastModified,
+		Checksums:     c.Checksums,
+		Integrity:     c.Integrity,
+		Parts:         []ManifestPart{},
+	}
+	if c.Storage == StoragePreallocated {
+		manifest.Preallocated = true
+		manifest.BlockSize = blockSizeOf(t.contentLength)
+	}
+	for i := 0; i < c.Parts; i++ {
+		begin, end := t.partRange(c.Parts, i)
+		manifest.Parts = append(manifest.Parts, ManifestPart{
+			File:  c.storageFileName(i),
+			Begin: begin,
+			End:   end,
+		})
+	}
+	return manifest
+}
+
+// loads the manifest of the last attempt and checks whether its parts can be resumed.
+// the parts which can not be resumed are removed. c.Parts follows the layout of the manifest.
+func (t *GettingTask) prepareManifest(c *GettingConfig) (*manifestFile, error) {
+	path := filepath.Join(c.PartsPath, c.manifestFileName())
+	manifest, err := LoadManifest(path)
+	adopted := false
+
+	if err != nil {
+		// the part files of the previous releases have no manifest, and are adopted as their sizes say.
+		// the blocks of a preallocated file are unknown without a manifest.
+		adopted = os.IsNotExist(err) && c.Storage != StoragePreallocated && t.rangeable && t.contentLength >= 0
+		if !adopted {
+			// the part files are foreign or truncated, and are removed with the broken manifest.
+			if err := t.cleanPartFiles(c); err != nil {
+				return nil, err
+			}
+		}
+		manifest = nil
+	} else if err := t.checkManifest(manifest, c); err != nil {
+		if err := RemoveManifest(path); err != nil {
+			return nil, err
+		}
+		var changedError RemoteChangedError
+		if errors.As(err, &changedError) && c.ListenRetry != nil {
+			c.ListenRetry(RetryEvent{
+				Part:    -1,
+				Attempt: 1,
+				Err:     changedError,
+			})
+		}
+		manifest = nil
+	}
+	resumed := adopted || manifest != nil
+
+	if manifest == nil {
+		manifest = t.createManifest(c)
+	} else {
+		c.Parts = len(manifest.Parts)
+		manifest.FinalURL = t.finalURL
+		manifest.Checksums = c.Checksums
+		manifest.Integrity = c.Integrity
+
+		if manifest.Preallocated {
+			t.checkPreallocatedFile(c, manifest)
+		}
+	}
+	if resumed {
+		for i := range manifest.Parts {
+			part := &manifest.Parts[i]
+			if manifest.Preallocated {
+				part.Completed = part.completedOfBlocks(manifest.BlockSize)
+				continue
+			}
+			partPath := filepath.Join(c.PartsPath, part.File)
+			info, err := os.Stat(partPath)
+			size := int64(0)
+
+			if err == nil {
+				size = info.Size()
+			}
+			if size < part.Completed || size > part.End-part.Begin+1 {
+				// truncated or foreign.
+				if err := removeIfExists(partPath); err != nil {
+					return nil, err
+				}
+				size = 0
+			}
+			part.Completed = size
+		}
+	}
+	return &manifestFile{
+		path:     path,
+		manifest: manifest,
+	}, nil
+}
+
+// returns an error if the parts of the manifest can not be resumed by the task.
+func (t *GettingTask) checkManifest(manifest *Manifest, c *GettingConfig) error {
+	if manifest.URL != t.url {
+		return errors.Errorf("manifest belongs to another URL %q", manifest.URL)
+	}
+	if validator := manifest.ETag; validator != "" || t.etag != "" {
+		if validator != t.etag {
+			return RemoteChangedError{Expected: validator, Actual: t.etag}
+		}
+	} else if manifest.LastModified != t.lastModified {
+		return RemoteChangedError{Expected: manifest.LastModified, Actual: t.lastModified}
+	}
+	if manifest.ContentLength != t.contentLength {
+		return errors.Errorf("manifest has another content length %d", manifest.ContentLength)
+	}
+	if !t.rangeable || t.contentLength < 0 {
+		return errors.New("the download can not be resumed")
+	}
+	if len(manifest.Parts) == 0 {
+		return errors.New("manifest has no parts")
+	}
+	if manifest.Preallocated != (c.Storage == StoragePreallocated) {
+		return errors.New("manifest has another storage mode")
+	}
+	if manifest.Preallocated && manifest.BlockSize <= 0 {
+		return errors.New("manifest has an invalid block size")
+	}
+	layout := *c
+	layout.Parts = len(manifest.Parts)
+
+	for i, part := range manifest.Parts {
+		begin, end := t.partRange(layout.Parts, i)
+		if part.Begin != begin || part.End != end || part.File != layout.storageFileName(i) {
+			return errors.Errorf("manifest has an invalid layout of part %d", i)
+		}
+	}
+	return nil
+}
+
+// forgets all completed blocks if the preallocated file is missing or has another size.
+func (t *GettingTask) checkPreallocatedFile(c *GettingConfig, manifest *Manifest) {
+	info, err := os.Stat(filepath.Join(c.PartsPath, c.preallocatedFileName()))
+	if err == nil && info.Size() == t.contentLength {
+		return
+	}
+	for i := range manifest.Parts {
+		manifest.Parts[i].Blocks = nil
+	}
+}
@@ -0,0 +1,365 @@
+package oget
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MirrorStats is the statistics of a source of the file, i.e. RemoteFile.URL or one of RemoteFile.Mirrors.
+type MirrorStats struct {
+	// the URL of the mirror.
+	URL string
+	// whether the mirror is still used to download the parts.
+	// a mirror is excluded once its probe fails, it serves another file, a request to it fails with an error
+	// which is not retryable (see IsRetryable), or 3 requests to it fail in a row, while other mirrors are healthy.
+	// a mirror which fails less is only not used for a while (1 second, doubled by every failure in a row).
+	Healthy bool
+	// the error which excluded the mirror, or the last error of the mirror. the value is nil if nothing failed.
+	Err error
+	// the number of the requests sent to download the file, excluding the probe.
+	Requests int
+	// the number of the failed requests.
+	Failures int
+	// the bytes received from the mirror.
+	Bytes int64
+	// the total time spent by the requests. the requests of different parts may overlap.
+	Duration time.Duration
+}
+
+// returns the average bytes per second of a request to the mirror. returns 0 if nothing is received.
+func (s MirrorStats) Speed() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// MirrorMismatchError is the error of a mirror which serves another file than the one of RemoteFile.URL.
+type MirrorMismatchError struct {
+	// the URL of the mirror.
+	URL string
+	// the property which differs, e.g. "content length".
+	Property string
+	// the value of RemoteFile.URL.
+	Expected string
+	// the value of the mirror.
+	Actual string
+}
+
+func (e MirrorMismatchError) Error() string {
+	return fmt.Sprintf("mirror %q has %s %q, want %q", e.URL, e.Property, e.Actual, e.Expected)
+}
+
+// StallError is the error of a request which receives no bytes for RemoteFile.StallTimeout.
+type StallError struct {
+	// the URL of the mirror.
+	URL string
+	// the timeout which was exceeded.
+	Timeout time.Duration
+}
+
+func (e StallError) Error() string {
+	return fmt.Sprintf("no bytes received from %q for %s", e.URL, e.Timeout)
+}
+
+// the number of the requests failed in a row, which excludes the mirror.
+const mirrorMaxFaults = 3
+
+// the time not to use a mirror after its first failure, which doubles with every failure in a row.
+const mirrorCooldown = time.Second
+
+type mirror struct {
+	url          string
+	etag         string
+	lastModified string
+	healthy      bool
+	err          error
+	// the number of the requests failed in a row.
+	faults int
+	// the mirror is not used until then, unless no other mirror can be used.
+	cooldownUntil time.Time
+	// the number of the requests in progress.
+	active   int
+	requests int
+	failures int
+	bytes    int64
+	duration time.Duration
+}
+
+func (m *mirror) speed() float64 {
+	if m.duration <= 0 {
+		return 0
+	}
+	return float64(m.bytes) / m.duration.Seconds()
+}
+
+// the sources of the file. the first one is RemoteFile.URL.
+type mirrorSet struct {
+	mux     sync.Mutex
+	mirrors []*mirror
+}
+
+// probes RemoteFile.URL and its mirrors at the same time.
+// the result of RemoteFile.URL is the reference, or the result of the first mirror probed if RemoteFile.URL fails.
+// the mirrors which fail or differ from the reference are unhealthy.
+func probeMirrors(client *http.Client, c *RemoteFile, ctx context.Context) (*probeResult, *mirrorSet, error) {
+	urls := append([]string{c.URL}, c.Mirrors...)
+	results := make([]*probeResult, len(urls))
+	errs := make([]error, len(urls))
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		i, url := i, url
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config := *c
+			config.URL = url
+			results[i], errs[i] = probeRemoteFile(client, &config, ctx)
+		}()
+	}
+	wg.Wait()
+
+	var reference *probeResult
+	for i := range urls {
+		if errs[i] == nil {
+			reference = results[i]
+			break
+		}
+	}
+	if reference == nil {
+		return nil, nil, errs[0]
+	}
+	set := &mirrorSet{}
+
+	for i, url := range urls {
+		m := &mirror{url: url, healthy: true}
+		if errs[i] == nil {
+			m.etag = results[i].etag
+			m.lastModified = results[i].lastModified
+			errs[i] = checkMirror(url, reference, results[i])
+		}
+		if errs[i] != nil {
+			m.healthy = false
+			m.err = errs[i]
+		}
+		set.mirrors = append(set.mirrors, m)
+	}
+	return reference, set, nil
+}
+
+// returns MirrorMismatchError if the mirror does not serve the same file as the reference.
+// the strong ETags are compared if both servers tell them, while the weak ones and Last-Modified
+// are ignored, because they usually differ across servers.
+func checkMirror(url string, reference *probeResult, result *probeResult) error {
+	if result.contentLength != reference.contentLength {
+		return MirrorMismatchError{
+			URL:      url,
+			Property: "content length",
+			Expected: strconv.FormatInt(reference.contentLength, 10),
+			Actual:   strconv.FormatInt(result.contentLength, 10),
+		}
+	}
+	if result.rangeable != reference.rangeable {
+		return MirrorMismatchError{
+			URL:      url,
+			Property: "range support",
+			Expected: strconv.FormatBool(reference.rangeable),
+			Actual:   strconv.FormatBool(result.rangeable),
+		}
+	}
+	if isStrongETag(reference.etag) && isStrongETag(result.etag) && reference.etag != result.etag {
+		return MirrorMismatchError{URL: url, Property: "ETag", Expected: reference.etag, Actual: result.etag}
+	}
+	for _, expected := range reference.digests {
+		for _, actual := range result.digests {
+			if expected.Algorithm == actual.Algorithm && expected.Digest != actual.Digest {
+				return MirrorMismatchError{
+					URL:      url,
+					Property: expected.Algorithm + " digest",
+					Expected: expected.Digest,
+					Actual:   actual.Digest,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isStrongETag(etag string) bool {
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
+
+// returns the healthy mirror with the fewest requests in progress, and the fastest one of them.
+// the mirror must be released after the request.
+func (s *mirrorSet) acquire() *mirror {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var selected *mirror
+	now := time.Now()
+	for _, coolingDown := range []bool{false, true} {
+		for _, m := range s.mirrors {
+			if !m.healthy || (!coolingDown && now.Before(m.cooldownUntil)) {
+				continue
+			}
+			if selected == nil || m.active < selected.active ||
+				(m.active == selected.active && m.speed() > selected.speed()) {
+				selected = m
+			}
+		}
+		if selected != nil {
+			break
+		}
+	}
+	if selected == nil {
+		// never happens, because the last healthy mirror is kept.
+		selected = s.mirrors[0]
+	}
+	selected.active += 1
+	return selected
+}
+
+// records the result of the request to the mirror.
+// returns true if the mirror is failed and the request should be sent to another mirror.
+// the mirror is excluded by an error which is not retryable, or by mirrorMaxFaults errors in a row.
+// otherwise it cools down, and is used again later. the last healthy mirror is never excluded,
+// so that it can be retried by RetryPolicy.
+func (s *mirrorSet) release(m *mirror, bytes int64, duration time.Duration, err error) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	m.active -= 1
+	m.requests += 1
+	m.bytes += bytes
+	m.duration += duration
+
+	if err == nil {
+		m.faults = 0
+		return false
+	}
+	m.failures += 1
+	m.err = err
+
+	if !isMirrorFault(err) {
+		return false
+	}
+	m.faults += 1
+	now := time.Now()
+	m.cooldownUntil = now.Add(mirrorCooldown << (m.faults - 1))
+
+	// whether another mirror is healthy, and whether it can be used now.
+	healthy, available := false, false
+	for _, other := range s.mirrors {
+		if other != m && other.healthy {
+			healthy = true
+			available = available || !now.Before(other.cooldownUntil)
+		}
+	}
+	if healthy && (!IsRetryable(err) || m.faults >= mirrorMaxFaults) {
+		m.healthy = false
+		return true
+	}
+	return available
+}
+
+func (s *mirrorSet) stats() []MirrorStats {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	stats := []MirrorStats{}
+	for _, m := range s.mirrors {
+		stats = append(stats, MirrorStats{
+			URL:      m.url,
+			Healthy:  m.healthy,
+			Err:      m.err,
+			Requests: m.requests,
+			Failures: m.failures,
+			Bytes:    m.bytes,
+			Duration: m.duration,
+		})
+	}
+	return stats
+}
+
+// reports whether the error is caused by the mirror, rather than the local disk or the canceling.
+// a timeout of connecting to the mirror is its fault.
+func isMirrorFault(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var mergeError MergeError
+	if errors.As(err, &mergeError) {
+		return false
+	}
+	var pathError *fs.PathError
+	return !errors.As(err, &pathError)
+}
+
+// cancels the request once no bytes are received for the timeout, while waiting for the response
+// or reading the body. the time waiting for the rate limiters is not counted.
+type stallWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// the timer starts immediately. if the timeout is less than or equal to 0, the request never stalls.
+func watchStall(ctx context.Context, url string, timeout time.Duration) *stallWatcher {
+	w := &stallWatcher{timeout: timeout}
+	w.ctx, w.cancel = context.WithCancelCause(ctx)
+
+	if timeout > 0 {
+		w.timer = time.AfterFunc(timeout, func() {
+			w.cancel(StallError{URL: url, Timeout: timeout})
+		})
+	}
+	return w
+}
+
+// returns the reader of the body, which only counts the time of reading.
+func (w *stallWatcher) reader(reader io.Reader) io.Reader {
+	if w.timer == nil {
+		return reader
+	}
+	w.timer.Stop()
+	return &stallReader{reader: reader, watcher: w}
+}
+
+// returns StallError instead of the error caused by the canceling.
+func (w *stallWatcher) check(err error) error {
+	var stallError StallError
+	if err != nil && errors.As(context.Cause(w.ctx), &stallError) {
+		return stallError
+	}
+	return err
+}
+
+func (w *stallWatcher) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel(nil)
+}
+
+type stallReader struct {
+	reader  io.Reader
+	watcher *stallWatcher
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.watcher.timer.Reset(r.watcher.timeout)
+	n, err := r.reader.Read(p)
+	r.watcher.timer.Stop()
+	return n, err
+}
@@ -0,0 +1,132 @@
+package oget
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// the cause of the requests canceled by GettingTask.Pause.
+var errPaused = errors.New("the task is paused")
+
+// pauses and resumes the parts of a task. the requests are canceled when paused,
+// and the parts wait at the beginning of their next attempts until resumed.
+type pauser struct {
+	mux     sync.Mutex
+	paused  bool
+	resumed chan struct{}
+	nextID  int
+	cancels map[int]context.CancelCauseFunc
+	// the listeners of the changes, by their IDs.
+	listeners map[int]func(paused bool)
+}
+
+func newPauser() *pauser {
+	return &pauser{
+		cancels:   map[int]context.CancelCauseFunc{},
+		listeners: map[int]func(paused bool){},
+	}
+}
+
+// pauses the parts. returns false if they have been paused.
+func (p *pauser) pause() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.paused {
+		return false
+	}
+	p.paused = true
+	p.resumed = make(chan struct{})
+	for _, cancel := range p.cancels {
+		cancel(errPaused)
+	}
+	for _, listener := range p.listeners {
+		listener(true)
+	}
+	return true
+}
+
+// resumes the parts. returns false if they are not paused.
+func (p *pauser) resume() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !p.paused {
+		return false
+	}
+	p.paused = false
+	close(p.resumed)
+	for _, listener := range p.listeners {
+		listener(false)
+	}
+	return true
+}
+
+func (p *pauser) isPaused() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.paused
+}
+
+// waits until the parts are resumed, or the context is done.
+func (p *pauser) wait(ctx context.Context) error {
+	p.mux.Lock()
+	paused, resumed := p.paused, p.resumed
+	p.mux.Unlock()
+
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// returns the context of an attempt, which is canceled with errPaused once paused.
+// the function returned must be called when the attempt ends.
+func (p *pauser) watch(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	p.mux.Lock()
+	id := p.nextID
+	p.nextID += 1
+	p.cancels[id] = cancel
+	if p.paused {
+		cancel(errPaused)
+	}
+	p.mux.Unlock()
+
+	return ctx, func() {
+		p.mux.Lock()
+		delete(p.cancels, id)
+		p.mux.Unlock()
+		cancel(nil)
+	}
+}
+
+// calls the listener on every change until the function returned is called, and at once if paused.
+// the listener is called with the lock held, and must not call the pauser.
+func (p *pauser) listen(listener func(paused bool)) func() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	id := p.nextID
+	p.nextID += 1
+	p.listeners[id] = listener
+	if p.paused {
+		listener(true)
+	}
+
+	return func() {
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		delete(p.listeners, id)
+	}
+}
+
+// returns whether the context of an attempt is canceled by pausing, rather than its parent.
+func isPausedContext(ctx context.Context) bool {
+	return ctx.Err() != nil && context.Cause(ctx) == errPaused
+}
@@ -0,0 +1,200 @@
+package oget
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProbeMethod is the way to get the file information from the server.
+type ProbeMethod int
+
+const (
+	// ProbeHeadThenGet sends a HEAD request first, and falls back to a ranged GET request
+	// if the HEAD request is rejected or does not tell the length and range support.
+	ProbeHeadThenGet ProbeMethod = iota
+	// ProbeHead only sends a HEAD request.
+	ProbeHead
+	// ProbeGet only sends a GET request with `Range: bytes=0-0`.
+	// it fits presigned URLs which are bound to the GET method.
+	ProbeGet
+)
+
+// the max bytes to drain from the body of a probe response, so that its connection can be reused.
+const probeDrainLimit = 64 * 1024
+
+type probeResult struct {
+	contentLength int64
+	rangeable     bool
+	filename      string
+	finalURL      string
+	etag          string
+	lastModified  string
+	digests       []Checksum
+}
+
+func (r *probeResult) complete() bool {
+	return r.rangeable && r.contentLength > 0
+}
+
+func probeRemoteFile(client *http.Client, c *RemoteFile, ctx context.Context) (*probeResult, error) {
+	switch c.Probe {
+	case ProbeHead:
+		return probeWithHead(client, c, ctx)
+	case ProbeGet:
+		return probeWithGet(client, c, ctx)
+	default:
+		result, err := probeWithHead(client, c, ctx)
+		if err == nil && result.complete() {
+			return result, nil
+		}
+		getResult, getErr := probeWithGet(client, c, ctx)
+		if getErr != nil {
+			if err == nil {
+				// the HEAD request is still better than nothing.
+				return result, nil
+			}
+			return nil, getErr
+		}
+		return getResult, nil
+	}
+}
+
+func probeWithHead(client *http.Client, c *RemoteFile, ctx context.Context) (*probeResult, error) {
+	req, err := createProbeRequest(c, ctx, "HEAD")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make head request")
+	}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to head request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, createHTTPStatusError(resp)
+	}
+	return &probeResult{
+		// -1 if the length is not told (e.g. chunked), while 0 is an empty file.
+		contentLength: resp.ContentLength,
+		rangeable:     resp.Header.Get("Accept-Ranges") == "bytes",
+		filename:      filenameOfResponse(resp),
+		finalURL:      resp.Request.URL.String(),
+		etag:          resp.Header.Get("ETag"),
+		lastModified:  resp.Header.Get("Last-Modified"),
+		digests:       digestsOfResponse(resp),
+	}, nil
+}
+
+func probeWithGet(client *http.Client, c *RemoteFile, ctx context.Context) (*probeResult, error) {
+	req, err := createProbeRequest(c, ctx, "GET")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to make probe request")
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to probe request")
+	}
+	defer resp.Body.Close()
+
+	// reads the rest of body (only 1 byte if the range is respected),
+	// so that the connection can be reused by the first part.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, probeDrainLimit))
+
+	result := &probeResult{
+		contentLength: -1,
+		filename:      filenameOfResponse(resp),
+		finalURL:      resp.Request.URL.String(),
+		etag:          resp.Header.Get("ETag"),
+		lastModified:  resp.Header.Get("Last-Modified"),
+		digests:       digestsOfResponse(resp),
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		_, _, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid response of probe request")
+		}
+		if total > 0 {
+			result.contentLength = total
+			result.rangeable = true
+		}
+	case http.StatusOK:
+		// the server ignores the range, so the body is the whole file.
+		result.contentLength = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the file is empty and has no byte 0.
+		result.contentLength = 0
+	default:
+		return nil, createHTTPStatusError(resp)
+	}
+	return result, nil
+}
+
+func createProbeRequest(c *RemoteFile, ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if c.Useragent != "" {
+		req.Header.Set("User-Agent", c.Useragent)
+	}
+	if c.Referer != "" {
+		req.Header.Set("Referer", c.Referer)
+	}
+	return req, nil
+}
+
+func filenameOfResponse(resp *http.Response) string {
+	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if len(params) > 0 && params["filename"] != "" {
+		return params["filename"]
+	}
+	return ""
+}
+
+// parses the value of Content-Range header like `bytes 0-99/1000`.
+// total is -1 if the server does not know it (`bytes 0-99/*`).
+func parseContentRange(value string) (begin int64, end int64, total int64, err error) {
+	unit, spec, found := strings.Cut(strings.TrimSpace(value), " ")
+	if !found || unit != "bytes" {
+		return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+	}
+	rangeSpec, totalSpec, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+	}
+	total = -1
+	if totalSpec != "*" {
+		total, err = strconv.ParseInt(totalSpec, 10, 64)
+		if err != nil || total < 0 {
+			return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+		}
+	}
+	if rangeSpec == "*" {
+		return -1, -1, total, nil
+	}
+	beginSpec, endSpec, found := strings.Cut(rangeSpec, "-")
+	if !found {
+		return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+	}
+	begin, err = strconv.ParseInt(beginSpec, 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+	}
+	end, err = strconv.ParseInt(endSpec, 10, 64)
+	if err != nil || end < begin || (total >= 0 && end >= total) {
+		return 0, 0, 0, errors.Errorf("invalid content range %q", value)
+	}
+	return begin, end, total, nil
+}
@@ -1,8 +1,10 @@
 package oget
 
 import (
+	"context"
 	"io"
-	"sync/atomic"
+	"sync"
+	"time"
 )
 
 type ProgressPhase int
@@ -14,8 +16,19 @@ const (
 	ProgressPhaseCoping
 	// ProgressPhaseDone is the phase of downloading done.
 	ProgressPhaseDone
+	// ProgressPhaseVerifying is the phase of verifying the checksum of the downloaded file,
+	// which is between ProgressPhaseDownloading and ProgressPhaseCoping. the values do not follow the order
+	// of the phases, so that the values of the phases before it are kept.
+	// if the checksum has been computed while downloading, only one event of this phase will be fired.
+	ProgressPhaseVerifying
 )
 
+// the window to compute ProgressEvent.Speed.
+const speedWindow = 3 * time.Second
+
+// the minimum interval between two samples of the speed.
+const speedSampleInterval = 100 * time.Millisecond
+
 // ProgressListener is the listener of the progress.
 type ProgressListener func(event ProgressEvent)
 
@@ -23,65 +36,385 @@ type ProgressListener func(event ProgressEvent)
 type ProgressEvent struct {
 	// the phase of the progress.
 	Phase ProgressPhase
-	// the progress of the downloading (bytes).
+	// the progress of the downloading (bytes), including the Resumed bytes.
 	Progress int64
 	// the total length of the downloading (bytes).
+	// the value is -1 if the length is unknown (e.g. chunked transfer encoding) during downloading.
 	Total int64
+	// the bytes saved by the previous attempts of the downloading, which are counted in Progress.
+	// the value is 0 in the phases other than downloading.
+	Resumed int64
+	// the bytes per second in the last few seconds.
+	Speed float64
+	// the bytes per second since the phase began, excluding the Resumed bytes.
+	AverageSpeed float64
+	// the estimated time to complete the phase.
+	// the value is -1 if it can not be estimated, e.g. the length is unknown or no byte is received yet.
+	ETA time.Duration
+	// the index of the part which the bytes of the event belong to.
+	// the value is -1 if the event does not belong to a part, e.g. in the phases other than downloading.
+	Part int
+	// the offset of the first byte of the part in the file. the value is 0 if the event does not belong to a part.
+	PartOffset int64
+	// the length of the part. the value is -1 if the length is unknown, and 0 if the event does not belong to a part.
+	PartLength int64
+	// the bytes of the part completed, including the resumed ones. the value is 0 if the event does not belong to a part.
+	PartProgress int64
+	// the number of the retries of all parts so far, including the requests sent to another mirror.
+	Retries int
+	// whether the downloading is paused by GettingTask.Pause.
+	// an event is fired when it is paused or resumed.
+	Paused bool
 }
 
 type progress struct {
+	mux      sync.Mutex
 	phase    ProgressPhase
 	length   int64
 	progress int64
-	handler  func(event ProgressEvent)
+	resumed  int64
+	retries  int
+	paused   bool
+	begin    time.Time
+	parts    []progressPart
+	samples  []progressSample
+	// the value is nil if the progress is not listened, and only counted.
+	handler func(event ProgressEvent)
+}
+
+type progressPart struct {
+	offset int64
+	// the value is -1 if the length is unknown.
+	length    int64
+	completed int64
+}
+
+type progressSample struct {
+	time     time.Time
+	progress int64
 }
 
 func downloadingProgress(length int64, handler func(event ProgressEvent)) *progress {
+	now := time.Now()
 	return &progress{
 		phase:    ProgressPhaseDownloading,
 		length:   length,
 		handler:  handler,
 		progress: 0,
+		begin:    now,
+		samples:  []progressSample{{time: now}},
 	}
 }
 
-func (p *progress) toCopingPhase() *progress {
+// sets the parts of the downloading, whose completed bytes are resumed.
+func (p *progress) resume(parts []ManifestPart) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.parts = []progressPart{}
+	p.resumed = 0
+	for _, part := range parts {
+		length := int64(-1)
+		if part.End >= 0 {
+			length = part.End - part.Begin + 1
+		}
+		p.parts = append(p.parts, progressPart{
+			offset:    part.Begin,
+			length:    length,
+			completed: part.Completed,
+		})
+		p.resumed += part.Completed
+	}
+	p.progress = p.resumed
+	p.begin = time.Now()
+	p.samples = []progressSample{{time: p.begin, progress: p.progress}}
+}
+
+func (p *progress) toPhase(phase ProgressPhase) *progress {
+	p.mux.Lock()
+	length := p.length
+	if length < 0 {
+		// the length is known after all bytes are downloaded.
+		length = p.progress
+	}
+	retries := p.retries
+	p.mux.Unlock()
+
+	now := time.Now()
 	return &progress{
-		phase:    ProgressPhaseCoping,
-		length:   p.length,
+		phase:    phase,
+		length:   length,
 		handler:  p.handler,
 		progress: 0,
+		retries:  retries,
+		begin:    now,
+		samples:  []progressSample{{time: now}},
 	}
 }
 
 func (p *progress) reader(proxy io.Reader) io.Reader {
-	return &progressReader{parent: p, proxy: proxy}
+	return &progressReader{parent: p, proxy: proxy, part: -1}
+}
+
+// returns the reader of the bytes of the part.
+func (p *progress) partReader(part int, proxy io.Reader) io.Reader {
+	return &progressReader{parent: p, proxy: proxy, part: part}
+}
+
+// takes back the bytes of the part which will be downloaded again.
+func (p *progress) rollback(part int, bytes int64) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.progress -= bytes
+	if part >= 0 && part < len(p.parts) {
+		p.parts[part].completed -= bytes
+	}
+}
+
+// counts a retry of a part.
+func (p *progress) retry() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.retries += 1
+}
+
+// fires the event of pausing or resuming.
+func (p *progress) pause(paused bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.paused = paused
+	if p.handler != nil {
+		p.handler(p.eventLocked(-1))
+	}
+}
+
+// fires the event of completing all bytes of the phase at once.
+func (p *progress) fireCompleted() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.progress = p.length
+	if p.handler != nil {
+		p.handler(p.eventLocked(-1))
+	}
 }
 
 func (p *progress) fireDone() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.handler == nil {
+		return
+	}
 	p.handler(ProgressEvent{
 		Phase:    ProgressPhaseDone,
 		Total:    p.length,
 		Progress: p.length,
+		Part:     -1,
+		Retries:  p.retries,
 	})
 }
 
+// adds the bytes of the part, and fires the event.
+func (p *progress) add(part int, bytes int64) {
+	p.progress += bytes
+	if part >= 0 && part < len(p.parts) {
+		p.parts[part].completed += bytes
+	}
+	if p.handler != nil {
+		p.handler(p.eventLocked(part))
+	}
+}
+
+// returns the bytes completed in the phase excluding the resumed ones, the resumed bytes, and the retries.
+func (p *progress) summary() (int64, int64, int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.progress - p.resumed, p.resumed, p.retries
+}
+
+func (p *progress) eventLocked(part int) ProgressEvent {
+	now := time.Now()
+	event := ProgressEvent{
+		Phase:    p.phase,
+		Total:    p.length,
+		Progress: p.progress,
+		Resumed:  p.resumed,
+		ETA:      -1,
+		Part:     part,
+		Retries:  p.retries,
+		Paused:   p.paused,
+	}
+	if part >= 0 && part < len(p.parts) {
+		event.PartOffset = p.parts[part].offset
+		event.PartLength = p.parts[part].length
+		event.PartProgress = p.parts[part].completed
+	}
+	if elapsed := now.Sub(p.begin); elapsed > 0 {
+		event.AverageSpeed = max(0, float64(p.progress-p.resumed)/elapsed.Seconds())
+	}
+	if now.Sub(p.samples[len(p.samples)-1].time) >= speedSampleInterval {
+		p.samples = append(p.samples, progressSample{time: now, progress: p.progress})
+	}
+	for len(p.samples) > 1 && now.Sub(p.samples[0].time) > speedWindow {
+		p.samples = p.samples[1:]
+	}
+	if elapsed := now.Sub(p.samples[0].time); elapsed > 0 {
+		event.Speed = max(0, float64(p.progress-p.samples[0].progress)/elapsed.Seconds())
+	}
+	if p.length >= 0 {
+		if remaining := p.length - p.progress; remaining <= 0 {
+			event.ETA = 0
+		} else if event.Speed > 0 {
+			event.ETA = time.Duration(float64(remaining) / event.Speed * float64(time.Second))
+		}
+	}
+	return event
+}
+
 type progressReader struct {
 	parent *progress
 	proxy  io.Reader
+	part   int
 }
 
 func (r *progressReader) Read(p []byte) (int, error) {
 	n, err := r.proxy.Read(p)
-	if err != nil {
+	if n == 0 {
 		return n, err
 	}
 	parent := r.parent
-	progress := atomic.AddInt64(&parent.progress, int64(n))
-	r.parent.handler(ProgressEvent{
-		Phase:    parent.phase,
-		Total:    parent.length,
-		Progress: progress,
-	})
+	parent.mux.Lock()
+	defer parent.mux.Unlock()
+	parent.add(r.part, int64(n))
 	return n, err
 }
+
+// delivers the events to the listener and the channel in a goroutine, so that they are never called
+// concurrently and never block the downloading. the events of a phase are coalesced to one per interval
+// (unless the interval is negative), while the first and the last events of every phase, and the events
+// of pausing and resuming, are always delivered.
+type progressDelivery struct {
+	mux sync.Mutex
+	// the context of the task. once it is done, the events are not waited to be received from the channel.
+	ctx      context.Context
+	interval time.Duration
+	listener ProgressListener
+	channel  chan<- ProgressEvent
+	// the events which must be delivered in order.
+	queue []ProgressEvent
+	// the latest event which can be coalesced. it is after all events of the queue.
+	latest   *ProgressEvent
+	phase    ProgressPhase
+	paused   bool
+	started  bool
+	closed   bool
+	wake     chan struct{}
+	finished chan struct{}
+}
+
+// the maximum number of events queued for a listener which falls behind, if every event is delivered.
+const maxProgressQueue = 1024
+
+func newProgressDelivery(ctx context.Context, interval time.Duration, listener ProgressListener, channel chan<- ProgressEvent) *progressDelivery {
+	d := &progressDelivery{
+		ctx:      ctx,
+		interval: interval,
+		listener: listener,
+		channel:  channel,
+		wake:     make(chan struct{}, 1),
+		finished: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *progressDelivery) send(event ProgressEvent) {
+	d.mux.Lock()
+	transition := !d.started || event.Phase != d.phase || event.Paused != d.paused || event.Phase == ProgressPhaseDone
+	// every event is queued if the interval is negative, until the listener falls behind too much.
+	if transition || (d.interval < 0 && len(d.queue) < maxProgressQueue) {
+		// the last event before the transition, and the first event after it.
+		if d.latest != nil {
+			d.queue = append(d.queue, *d.latest)
+			d.latest = nil
+		}
+		d.queue = append(d.queue, event)
+		d.phase = event.Phase
+		d.paused = event.Paused
+		d.started = true
+	} else {
+		d.latest = &event
+	}
+	d.mux.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// delivers the rest of the events and waits for them. the channel is not closed, which is owned by the caller.
+func (d *progressDelivery) close() {
+	d.mux.Lock()
+	d.closed = true
+	d.mux.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	<-d.finished
+}
+
+func (d *progressDelivery) run() {
+	defer close(d.finished)
+	lastDelivery := time.Time{}
+
+	for {
+		d.mux.Lock()
+		events := d.queue
+		d.queue = nil
+		wait := time.Duration(0)
+
+		if d.latest != nil && (len(events) == 0 || d.closed) {
+			// the latest event is delivered after the interval since the events of the queue.
+			wait = d.interval - time.Since(lastDelivery)
+			if wait <= 0 || d.closed {
+				events = append(events, *d.latest)
+				d.latest = nil
+				wait = 0
+			}
+		}
+		closed := d.closed
+		d.mux.Unlock()
+
+		if len(events) > 0 {
+			for _, event := range events {
+				if d.listener != nil {
+					d.listener(event)
+				}
+				if d.channel != nil {
+					select {
+					case d.channel <- event:
+					case <-d.ctx.Done():
+						// the caller may have stopped receiving, and Get must return.
+					}
+				}
+			}
+			lastDelivery = time.Now()
+			continue
+		}
+		if closed {
+			return
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-d.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		} else {
+			<-d.wake
+		}
+	}
+}
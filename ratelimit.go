@@ -0,0 +1,150 @@
+package oget
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the bandwidth with a token bucket.
+// it can be shared by multiple tasks to limit their total bandwidth.
+// the zero value is unlimited until SetLimit is called.
+type RateLimiter struct {
+	mux sync.Mutex
+	// the bytes per second. the value is less than or equal to 0 if unlimited.
+	limit int64
+	// the maximum bytes which can be read at once.
+	burst  int64
+	tokens float64
+	last   time.Time
+	// closed when the limit is changed, to wake up the waiting readers. the value is nil until the limit is set.
+	changed chan struct{}
+}
+
+// creates a RateLimiter which allows bytesPerSecond bytes per second, and burst bytes at once.
+// if bytesPerSecond is less than or equal to 0, the bandwidth is unlimited.
+// if burst is less than or equal to 0, it is the same as bytesPerSecond.
+func NewRateLimiter(bytesPerSecond int64, burst int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(bytesPerSecond, burst)
+	return l
+}
+
+// changes the limit. it takes effect immediately, even for the readers waiting for the bandwidth.
+func (l *RateLimiter) SetLimit(bytesPerSecond int64, burst int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	if l.limit <= 0 {
+		// the bucket is full when it starts to limit.
+		l.tokens = float64(burst)
+	}
+	l.limit = bytesPerSecond
+	l.burst = burst
+	l.tokens = min(l.tokens, float64(burst))
+	l.last = time.Now()
+
+	if l.changed != nil {
+		close(l.changed)
+	}
+	l.changed = make(chan struct{})
+}
+
+// returns the bytes per second and the burst. the bytes per second is 0 if unlimited.
+func (l *RateLimiter) Limit() (int64, int64) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.limit <= 0 {
+		return 0, 0
+	}
+	return l.limit, l.burst
+}
+
+// waits until n bytes are allowed, or the context is done.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	remaining := int64(n)
+	for remaining > 0 {
+		l.mux.Lock()
+		if l.limit <= 0 {
+			l.mux.Unlock()
+			return nil
+		}
+		now := time.Now()
+		l.tokens = min(float64(l.burst), l.tokens+now.Sub(l.last).Seconds()*float64(l.limit))
+		l.last = now
+
+		// takes no more than the burst at once, so that the bucket can hold them.
+		want := float64(min(remaining, l.burst))
+		if l.tokens >= want {
+			l.tokens -= want
+			remaining -= int64(want)
+			l.mux.Unlock()
+			continue
+		}
+		delay := time.Duration((want - l.tokens) / float64(l.limit) * float64(time.Second))
+		changed := l.changed
+		l.mux.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// returns the maximum bytes to read at once. returns 0 if unlimited.
+func (l *RateLimiter) chunkSize() int {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.limit <= 0 {
+		return 0
+	}
+	return int(l.burst)
+}
+
+// returns the reader which reads no faster than the limiters allow.
+func limitReader(ctx context.Context, reader io.Reader, limiters ...*RateLimiter) io.Reader {
+	active := []*RateLimiter{}
+	for _, limiter := range limiters {
+		if limiter != nil {
+			active = append(active, limiter)
+		}
+	}
+	if len(active) == 0 {
+		return reader
+	}
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiters: active}
+}
+
+type rateLimitedReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	for _, limiter := range r.limiters {
+		if size := limiter.chunkSize(); size > 0 && len(p) > size {
+			p = p[:size]
+		}
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, limiter := range r.limiters {
+			if waitErr := limiter.WaitN(r.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,40 @@
+package oget
+
+import "time"
+
+// Result is the result of GettingTask.Get, whose fields are filled as far as the downloading goes.
+type Result struct {
+	// the path of the file, see GettingConfig.FilePath.
+	FilePath string
+	// the URL of the file after redirects, which is probed by CreateGettingTask.
+	FinalURL string
+	// the bytes downloaded by this call of Get, excluding the Resumed ones and the ones downloaded again after failures.
+	Downloaded int64
+	// the bytes saved by the previous attempts, which are not downloaded again.
+	Resumed int64
+	// the time of probing the file by CreateGettingTask.
+	ProbeDuration time.Duration
+	// the time of downloading the parts, including the retries, the pauses and repairing the chunks.
+	DownloadDuration time.Duration
+	// the time of verifying the checksums. the value is 0 if no checksum is given.
+	VerifyDuration time.Duration
+	// the time of merging the parts into the file, including verifying the signature.
+	MergeDuration time.Duration
+	// the bytes per second of Downloaded during DownloadDuration.
+	AverageSpeed float64
+	// the number of the retries of all parts, including the requests sent to another mirror.
+	Retries int
+	// the digests of the file which have been verified, one per algorithm.
+	// the value is nil if no checksum is given or the file is not verified.
+	Digests []Checksum
+	clean   func() error
+}
+
+// removes the temp files of the download (the parts and the manifest), which are kept to resume.
+// it does nothing if the file is downloaded.
+func (r *Result) Clean() error {
+	if r.clean == nil {
+		return nil
+	}
+	return r.clean()
+}
@@ -0,0 +1,89 @@
+package oget
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy is the policy to retry a failed part of the downloading.
+// every part retries independently from the bytes it has already saved.
+type RetryPolicy struct {
+	// the maximum number of attempts of each part, including the first one.
+	// if the value is less than or equal to 1, the part will not be retried.
+	MaxAttempts int
+	// the backoff before the first retry. it doubles for each further retry.
+	// the default is 500 milliseconds.
+	BaseBackoff time.Duration
+	// the maximum backoff between two attempts.
+	// the default is 30 seconds.
+	MaxBackoff time.Duration
+	// the random fraction (from 0 to 1) of the backoff to cut off,
+	// so that parts failed at the same time will not retry at the same time.
+	// the default is 0 (no jitter).
+	Jitter float64
+}
+
+// RetryListener is the listener of the retrying.
+type RetryListener func(event RetryEvent)
+
+// RetryEvent is fired before a part of the downloading is retried.
+type RetryEvent struct {
+	// the index of the part. the value is -1 if all parts are restarted.
+	Part int
+	// the number of the attempt that will be made, 2 means the first retry.
+	Attempt int
+	// the time to wait before the attempt.
+	Delay time.Duration
+	// the error of the previous attempt.
+	Err error
+}
+
+func (p *RetryPolicy) standardize() RetryPolicy {
+	c := *p
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 1
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = time.Duration(500) * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = time.Duration(30) * time.Second
+	}
+	if c.MaxBackoff < c.BaseBackoff {
+		c.MaxBackoff = c.BaseBackoff
+	}
+	if c.Jitter < 0 {
+		c.Jitter = 0
+	} else if c.Jitter > 1 {
+		c.Jitter = 1
+	}
+	return c
+}
+
+// returns the backoff after the failed attempt (starts from 1).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * p.Jitter * rand.Float64())
+	}
+	return delay
+}
+
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
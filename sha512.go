@@ -1,42 +1,25 @@
 package oget
 
-import (
-	"crypto/sha512"
-	"fmt"
-	"io"
-	"os"
-)
-
 // returns the SHA512 code of the file.
 func SHA512(path string) (string, error) {
-	return sha512OfFiles(&[]string{path})
-}
-
-func sha512OfFiles(pathList *[]string) (string, error) {
-	h := sha512.New()
-	for _, path := range *pathList {
-		file, err := os.Open(path)
-		if err != nil {
-			return "", err
-		}
-		defer file.Close()
-		io.Copy(h, file)
-	}
-	hashInBytes := h.Sum(nil)
-	hashStr := fmt.Sprintf("%x", hashInBytes)
-
-	return hashStr, nil
+	return HashFile(path, "sha512")
 }
 
 // SHA512Error is the error type of SHA512.
+// it wraps the ChecksumError which tells the expected and the actual code.
 type SHA512Error struct {
-	message string
+	message  string
+	checksum ChecksumError
 }
 
 func (e SHA512Error) Error() string {
 	return e.message
 }
 
-func createSHA512Error(message string) SHA512Error {
-	return SHA512Error{message}
+func (e SHA512Error) Unwrap() error {
+	return e.checksum
+}
+
+func createSHA512Error(message string, checksum ChecksumError) SHA512Error {
+	return SHA512Error{message, checksum}
 }
@@ -0,0 +1,212 @@
+package oget
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureVerifier verifies the detached signature of the downloaded file.
+// implement it to plug in other schemes, e.g. OpenPGP.
+type SignatureVerifier interface {
+	// verifies the signature of the content. returns an error if the signature is invalid.
+	Verify(content io.Reader, signature []byte) error
+}
+
+// Signature describes the detached signature of the file.
+type Signature struct {
+	// the verifier with the public key, e.g. Ed25519Verifier or MinisignVerifier.
+	Verifier SignatureVerifier
+	// the content of the signature.
+	// if the value is empty, the signature will be fetched from URL before downloading.
+	Data []byte
+	// the URL of the signature, fetched with the settings of RemoteFile, e.g. "file.zip.minisig".
+	URL string
+}
+
+// SignatureError is the error of a downloaded file whose signature is invalid.
+// the file will not be moved to the FilePath.
+type SignatureError struct {
+	Err error
+}
+
+func (e SignatureError) Error() string {
+	return "invalid signature: " + e.Err.Error()
+}
+
+func (e SignatureError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultMaxSignedSize is the max size of the file whose signature is verified in the memory
+// (see Ed25519Verifier.MaxSize and MinisignVerifier.MaxLegacySize).
+const DefaultMaxSignedSize int64 = 1 << 30
+
+// Ed25519Verifier verifies the raw (or base64 encoded) Ed25519 signature of the file.
+// the whole file is read into the memory, because Ed25519 can not verify a stream.
+type Ed25519Verifier struct {
+	PublicKey ed25519.PublicKey
+	// the max size of the file to read into the memory. a larger file fails the verification.
+	// if the value is zero, DefaultMaxSignedSize is used.
+	MaxSize int64
+}
+
+func (v Ed25519Verifier) Verify(content io.Reader, signature []byte) error {
+	if len(v.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key")
+	}
+	signature, err := decodeSignature(signature, ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	message, err := readSignedContent(content, v.MaxSize)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(v.PublicKey, message, signature) {
+		return errors.New("ed25519 signature does not match")
+	}
+	return nil
+}
+
+func decodeSignature(signature []byte, size int) ([]byte, error) {
+	if len(signature) == size {
+		return signature, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(decoded) != size {
+		return nil, errors.New("invalid signature size")
+	}
+	return decoded, nil
+}
+
+// reads the whole content to verify, failing instead of exhausting the memory if it exceeds the limit.
+func readSignedContent(content io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultMaxSignedSize
+	}
+	message, err := io.ReadAll(io.LimitReader(content, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(message)) > limit {
+		return nil, errors.Errorf("file is larger than %d bytes to verify in the memory, sign it with a prehashed signature instead", limit)
+	}
+	return message, nil
+}
+
+// MinisignVerifier verifies the signature of minisign (https://jedisct1.github.io/minisign/).
+// both the prehashed (the default of minisign) and the legacy signatures are supported.
+// the legacy signatures (minisign -l) read the whole file into the memory.
+type MinisignVerifier struct {
+	// the max size of the file to verify with a legacy signature. a larger file fails the verification.
+	// if the value is zero, DefaultMaxSignedSize is used.
+	MaxLegacySize int64
+	keyID         [8]byte
+	publicKey     ed25519.PublicKey
+}
+
+// creates a MinisignVerifier with the public key, either the content of the public key file
+// ("untrusted comment: ..." followed by the key) or the key line only (e.g. "RWQ...").
+func NewMinisignVerifier(publicKey string) (*MinisignVerifier, error) {
+	lines := minisignLines(publicKey)
+	if len(lines) == 0 {
+		return nil, errors.New("invalid minisign public key")
+	}
+	key, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(key) != 2+8+ed25519.PublicKeySize || string(key[:2]) != "Ed" {
+		return nil, errors.New("invalid minisign public key")
+	}
+	verifier := &MinisignVerifier{publicKey: ed25519.PublicKey(key[10:])}
+	copy(verifier.keyID[:], key[2:10])
+	return verifier, nil
+}
+
+func (v *MinisignVerifier) Verify(content io.Reader, signature []byte) error {
+	lines := minisignLines(string(signature))
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], v.keyID[:]) {
+		return errors.New("minisign signature is signed by another key")
+	}
+	var message []byte
+
+	switch string(sig[:2]) {
+	case "ED":
+		h := newBlake2b512()
+		if _, err := io.Copy(h, content); err != nil {
+			return err
+		}
+		message = h.Sum(nil)
+	case "Ed":
+		message, err = readSignedContent(content, v.MaxLegacySize)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(v.publicKey, message, sig[10:]) {
+		return errors.New("minisign signature does not match")
+	}
+	trustedComment := strings.TrimPrefix(lines[1], "trusted comment: ")
+	globalMessage := append(append([]byte{}, sig[10:]...), trustedComment...)
+	if !ed25519.Verify(v.publicKey, globalMessage, globalSig) {
+		return errors.New("minisign trusted comment does not match")
+	}
+	return nil
+}
+
+// returns the lines without the untrusted comment and empty lines.
+func minisignLines(content string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// loads the signature from its URL if it is not given.
+func (t *GettingTask) loadSignature(signature *Signature) ([]byte, error) {
+	if len(signature.Data) > 0 {
+		return signature.Data, nil
+	}
+	if signature.URL == "" {
+		return nil, ConfigError{Err: errors.New("neither signature data nor URL is given")}
+	}
+	data, err := t.fetchSidecarFile(signature.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get signature")
+	}
+	return data, nil
+}
+
+func verifySignature(verifier SignatureVerifier, path string, signature []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return createMergeError(err, "failed to open file to verify signature")
+	}
+	defer file.Close()
+
+	if err := verifier.Verify(file, signature); err != nil {
+		return SignatureError{Err: err}
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"os"
 	"time"
@@ -17,10 +16,21 @@ import (
 
 type GettingTask struct {
 	url           string
+	finalURL      string
 	filename      string
 	useragent     string
 	referer       string
 	contentLength int64
+	rangeable     bool
+	etag          string
+	lastModified  string
+	digests       []Checksum
+	limiter       *RateLimiter
+	sharedLimiter *RateLimiter
+	mirrors       *mirrorSet
+	stallTimeout  time.Duration
+	probeDuration time.Duration
+	pauser        *pauser
 	client        *http.Client
 	context       context.Context
 	timeout       time.Duration
@@ -29,45 +39,36 @@ type GettingTask struct {
 // creates a new GettingTask. will access the URL to get the file information.
 func CreateGettingTask(config *RemoteFile) (*GettingTask, error) {
 	c := config.standardize()
-	client := newGettingClient(c.MaxIdleConnsPerHost)
+	client := c.Client
+	if client == nil {
+		client = newGettingClient(c.MaxIdleConnsPerHost)
+	}
 	ctx, cancel := context.WithTimeout(c.Context, c.Timeout)
 	defer cancel()
 
-	req, err := http.NewRequest("HEAD", c.URL, nil)
-
+	probeBegin := time.Now()
+	result, mirrors, err := probeMirrors(client, &c, ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to make head request")
-	}
-	req = req.WithContext(ctx)
-
-	if config.Useragent != "" {
-		req.Header.Set("User-Agent", config.Useragent)
-	}
-	if config.Referer != "" {
-		req.Header.Set("Referer", config.Referer)
-	}
-	resp, err := client.Do(req)
-
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to head request")
-	}
-	if resp.Header.Get("Accept-Ranges") != "bytes" {
-		return nil, errors.New("does not support range request")
-	}
-	if resp.ContentLength <= 0 {
-		return nil, errors.New("invalid content length")
-	}
-	filename := ""
-	_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
-	if len(params) > 0 && params["filename"] != "" {
-		filename = params["filename"]
+		// the probe timed out by RemoteFile.Timeout is worth retrying, unlike the done context of the caller.
+		return nil, checkContextDone(c.Context, err)
 	}
 	task := &GettingTask{
 		url:           c.URL,
-		filename:      filename,
+		finalURL:      result.finalURL,
+		filename:      result.filename,
 		useragent:     c.Useragent,
 		referer:       c.Referer,
-		contentLength: resp.ContentLength,
+		contentLength: result.contentLength,
+		rangeable:     result.rangeable,
+		etag:          result.etag,
+		lastModified:  result.lastModified,
+		digests:       result.digests,
+		limiter:       NewRateLimiter(c.RateLimit, c.RateBurst),
+		sharedLimiter: c.RateLimiter,
+		mirrors:       mirrors,
+		stallTimeout:  c.StallTimeout,
+		probeDuration: time.Since(probeBegin),
+		pauser:        newPauser(),
 		client:        client,
 		context:       c.Context,
 		timeout:       c.Timeout,
@@ -76,71 +77,238 @@ func CreateGettingTask(config *RemoteFile) (*GettingTask, error) {
 }
 
 // returns the content length of the file.
+// returns -1 if the server does not tell the length (e.g. chunked transfer encoding), and 0 if the file is empty.
+// in this case, the file will be downloaded in one part and every attempt will restart from the beginning.
 func (t *GettingTask) ContentLength() int64 {
 	return t.contentLength
 }
 
-// downloads the file.
-func (t *GettingTask) Get(config *GettingConfig) (func() error, error) {
-	var prog *progress
+// returns the file name told by the Content-Disposition header. returns empty string if the server does not tell it.
+func (t *GettingTask) FileName() string {
+	return t.filename
+}
+
+// returns whether the server supports range requests.
+// if not, the file will be downloaded in one part and every attempt will restart from the beginning.
+func (t *GettingTask) SupportsRange() bool {
+	return t.rangeable
+}
+
+// returns the ETag header value of the file. returns empty string if the server does not tell it.
+func (t *GettingTask) ETag() string {
+	return t.etag
+}
+
+// returns the Last-Modified header value of the file. returns empty string if the server does not tell it.
+func (t *GettingTask) LastModified() string {
+	return t.lastModified
+}
+
+// changes the bandwidth limit of the task, see RemoteFile.RateLimit.
+// it takes effect immediately, even while downloading.
+func (t *GettingTask) SetRateLimit(bytesPerSecond int64, burst int64) {
+	t.limiter.SetLimit(bytesPerSecond, burst)
+}
+
+// returns the statistics of RemoteFile.URL and RemoteFile.Mirrors in order.
+// it can be called while downloading.
+func (t *GettingTask) MirrorStats() []MirrorStats {
+	return t.mirrors.stats()
+}
+
+// pauses the downloading of Get. the requests in flight are canceled and their bytes are saved,
+// then the parts wait until Resume is called. it can also be called before Get.
+// the verifying and merging after downloading are not paused.
+// Get still returns once RemoteFile.Context is done while paused.
+func (t *GettingTask) Pause() {
+	t.pauser.pause()
+}
+
+// resumes the downloading paused by Pause. the parts continue from their saved bytes
+// with the file information probed by CreateGettingTask.
+func (t *GettingTask) Resume() {
+	t.pauser.resume()
+}
+
+// returns whether the task is paused by Pause.
+func (t *GettingTask) Paused() bool {
+	return t.pauser.isPaused()
+}
+
+// returns the digests of the file told by the server in the headers of the probe response
+// (Digest, Repr-Digest, Content-MD5 or x-goog-hash). returns nil if the server does not tell them.
+// the file will be checked against them if GettingConfig.VerifyServerDigest is true.
+func (t *GettingTask) Digests() []Checksum {
+	if len(t.digests) == 0 {
+		return nil
+	}
+	return append([]Checksum{}, t.digests...)
+}
+
+// downloads the file. the result is never nil, and its Clean removes the temp files kept to resume if it fails.
+func (t *GettingTask) Get(config *GettingConfig) (*Result, error) {
+	result, err := t.get(config)
+	return result, checkContextDone(t.context, err)
+}
+
+func (t *GettingTask) get(config *GettingConfig) (*Result, error) {
+	var handler func(event ProgressEvent)
 	c := config.standardize()
 	tasks := []*subTask{}
+	result := &Result{
+		FilePath:      c.FilePath,
+		FinalURL:      t.finalURL,
+		ProbeDuration: t.probeDuration,
+	}
+	if c.ListenProgress != nil || c.ProgressChannel != nil {
+		delivery := newProgressDelivery(t.context, c.ProgressInterval, c.ListenProgress, c.ProgressChannel)
+		defer delivery.close()
+		handler = delivery.send
+	}
+
+	expected, err := c.expectedDigests()
+	if err != nil {
+		return result, ConfigError{Err: err}
+	}
+	if c.VerifyServerDigest {
+		expected = append(expected, expectedDigestsOf(t.digests)...)
+	}
+	if c.ChecksumFile != nil {
+		checksum, err := t.discoverChecksum(c.ChecksumFile)
+		if err != nil {
+			return result, err
+		}
+		if checksum != nil {
+			expected = append(expected, expectedDigestsOf([]Checksum{*checksum})...)
+		}
+	}
 
-	if c.ListenProgress != nil {
-		prog = downloadingProgress(t.contentLength, c.ListenProgress)
+	if !t.rangeable || t.contentLength <= 0 {
+		// an empty file is downloaded in one part as well, since it has no byte to split.
+		c.Parts = 1
+		c.Storage = StoragePartFiles
+	}
+	if t.contentLength > 0 && int64(c.Parts) > t.contentLength {
+		c.Parts = int(t.contentLength)
+	}
+
+	// the progress is counted for the result even if it is not listened.
+	prog := downloadingProgress(t.contentLength, handler)
+	var chunks *Chunks
+	if c.Chunks != nil && t.rangeable && t.contentLength >= 0 {
+		chunks, err = t.loadChunks(c.Chunks)
+		if err != nil {
+			if len(c.Chunks.Digests) > 0 || c.Chunks.URL == "" {
+				// the chunks are given by the caller rather than fetched.
+				err = ConfigError{Err: err}
+			}
+			return result, err
+		}
+	}
+	var signature []byte
+	if c.Signature != nil {
+		if c.Signature.Verifier == nil {
+			return result, ConfigError{Err: errors.New("signature verifier is not given")}
+		}
+		signature, err = t.loadSignature(c.Signature)
+		if err != nil {
+			return result, err
+		}
+	}
+	manifest, err := t.prepareManifest(&c)
+	if err != nil {
+		return result, err
+	}
+	prog.resume(manifest.manifest.Parts)
+	// only the downloading can be paused.
+	stopListening := t.pauser.listen(prog.pause)
+	defer stopListening()
+
+	if chunks != nil {
+		manifest.chunks = newChunkVerifier(chunks)
+	}
+	if len(expected) > 0 {
+		manifest.chain, err = newHashChain(&c, manifest.manifest, algorithmsOf(expected))
+		if err != nil {
+			return result, err
+		}
 	}
 	for i := 0; i < c.Parts; i++ {
-		task := t.getPartTask(&c, i)
+		task := t.getPartTask(&c, manifest, i)
 		if task != nil {
 			tasks = append(tasks, task)
 		}
 	}
-	clean := func() error {
+	result.clean = func() error {
 		return t.cleanPartFiles(&c)
 	}
 	if len(tasks) > 0 {
 		err := os.MkdirAll(c.PartsPath, 0755)
 		if err != nil {
-			return clean, err
+			return result, err
+		}
+		if c.Storage == StoragePreallocated {
+			if err := t.preallocateFile(&c); err != nil {
+				return result, err
+			}
+		}
+		if err := manifest.save(); err != nil {
+			return result, err
 		}
 	}
 	eg, ctx := errgroup.WithContext(t.context)
+	phaseBegin := time.Now()
 
 	for _, task := range tasks {
 		task := task
 		eg.Go(func() error {
-			req, err := t.createRequest(ctx)
-			if err != nil {
-				return err
-			}
-			if len(tasks) > 1 || !tasks[0].overrideFile {
-				req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", task.begin, task.end))
-			}
-			if err := t.downloadToFile(req, task, prog); err != nil {
-				return err
-			}
-			return nil
+			return t.downloadPart(ctx, &c, task, manifest, prog)
 		})
 	}
-	if err := eg.Wait(); err != nil {
-		return clean, err
+	err = eg.Wait()
+	if err == nil && manifest.chunks != nil {
+		// the chunks across parts, or of the parts resumed, are verified at last.
+		err = t.verifyChunks(t.context, &c, manifest, 0, t.contentLength-1)
 	}
-	if prog != nil {
-		prog = prog.toCopingPhase()
+	stopListening()
+	result.DownloadDuration = time.Since(phaseBegin)
+	result.Downloaded, result.Resumed, result.Retries = prog.summary()
+	if seconds := result.DownloadDuration.Seconds(); seconds > 0 {
+		result.AverageSpeed = float64(result.Downloaded) / seconds
 	}
-	if err := t.mergeFile(&c, prog); err != nil {
-		return clean, err
+	if err != nil {
+		var changedError RemoteChangedError
+		if errors.As(err, &changedError) {
+			// the parts are stale and useless for the next attempt.
+			_ = result.Clean()
+		}
+		return result, err
 	}
-	if prog != nil {
-		prog.fireDone()
+	if len(expected) > 0 {
+		phaseBegin = time.Now()
+		prog = prog.toPhase(ProgressPhaseVerifying)
+		digests, err := t.verifyFile(&c, expected, manifest, prog)
+		result.VerifyDuration = time.Since(phaseBegin)
+		if err != nil {
+			return result, err
+		}
+		result.Digests = verifiedDigests(expected, digests)
 	}
-	clean = func() error { return nil }
+	phaseBegin = time.Now()
+	prog = prog.toPhase(ProgressPhaseCoping)
+	err = t.mergeFile(&c, manifest, prog, signature)
+	result.MergeDuration = time.Since(phaseBegin)
+	if err != nil {
+		return result, err
+	}
+	prog.fireDone()
+	result.clean = nil
 
-	return clean, nil
+	return result, nil
 }
 
-func (t *GettingTask) createRequest(ctx context.Context) (*http.Request, error) {
-	req, err := http.NewRequest("GET", t.url, nil)
+func (t *GettingTask) createRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to make a new request")
 	}
@@ -155,99 +323,296 @@ func (t *GettingTask) createRequest(ctx context.Context) (*http.Request, error)
 	return req, nil
 }
 
-func (t *GettingTask) downloadToFile(req *http.Request, task *subTask, prog *progress) error {
+func (t *GettingTask) downloadPart(ctx context.Context, c *GettingConfig, task *subTask, manifest *manifestFile, prog *progress) error {
+	for attempt := 1; ; {
+		if err := t.pauser.wait(ctx); err != nil {
+			return err
+		}
+		attemptCtx, done := t.pauser.watch(ctx)
+		m := t.mirrors.acquire()
+		watcher := watchStall(attemptCtx, m.url, t.stallTimeout)
+		beginTime := time.Now()
+		written, err := t.downloadToFile(watcher, m, c, task, manifest, prog)
+		watcher.stop()
+		paused := err != nil && isPausedContext(attemptCtx)
+		done()
+
+		var mirrorErr error
+		if !paused && ctx.Err() == nil {
+			// the mirror is not blamed for the canceling.
+			mirrorErr = err
+		}
+		failover := t.mirrors.release(m, written, time.Since(beginTime), mirrorErr)
+
+		partBegin, _ := t.partRange(c.Parts, task.index)
+		if saveErr := manifest.updatePart(task.index, task.begin-partBegin, task.begin-partBegin+written); saveErr != nil && err == nil {
+			err = saveErr
+		}
+		if err == nil {
+			if manifest.chain != nil {
+				manifest.chain.finish(task.index)
+			}
+			if manifest.chunks != nil {
+				part := manifest.part(task.index)
+				return t.verifyChunks(ctx, c, manifest, part.Begin, part.End)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		// switching to another mirror, or resuming after pausing, does not take an attempt.
+		if !paused && !failover {
+			if attempt >= c.Retry.MaxAttempts || !IsRetryable(err) {
+				return err
+			}
+			delay := c.Retry.backoff(attempt)
+			if c.ListenRetry != nil {
+				c.ListenRetry(RetryEvent{
+					Part:    task.index,
+					Attempt: attempt + 1,
+					Delay:   delay,
+					Err:     err,
+				})
+			}
+			if err := sleepWithContext(ctx, delay); err != nil {
+				return err
+			}
+			attempt++
+		}
+		if !paused {
+			prog.retry()
+		}
+		nextTask := t.getPartTask(c, manifest, task.index)
+		if nextTask == nil {
+			return nil
+		}
+		if nextTask.overrideFile {
+			// the bytes of the failed attempt will be downloaded again.
+			prog.rollback(task.index, written)
+			if manifest.chain != nil {
+				manifest.chain.reset(task.index)
+			}
+		}
+		task = nextTask
+	}
+}
+
+// downloads the part from the mirror into its file. the bytes are also written into the chain of the manifest if it is not nil.
+func (t *GettingTask) downloadToFile(watcher *stallWatcher, m *mirror, c *GettingConfig, task *subTask, manifest *manifestFile, prog *progress) (int64, error) {
+	req, err := t.createRequest(watcher.ctx, m.url)
+	if err != nil {
+		return 0, err
+	}
+	if c.Parts > 1 || !task.overrideFile {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", task.begin, task.end))
+		if ifRange := m.ifRangeValidator(); ifRange != "" {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
 	resp, err := t.client.Do(req)
 
 	if err != nil {
-		return errors.Wrapf(err, "failed to get response: %q", err)
+		err = watcher.check(err)
+		return 0, errors.Wrapf(err, "failed to get response: %q", err)
 	}
 	defer resp.Body.Close()
 
+	if err := t.checkResponse(req, resp, task, m); err != nil {
+		return 0, err
+	}
 	flag := os.O_WRONLY | os.O_CREATE
 
-	if !task.overrideFile {
+	if task.preallocated {
+		// writes at the offset of the part.
+	} else if task.overrideFile {
+		flag |= os.O_TRUNC
+	} else {
 		flag |= os.O_APPEND
 	}
-	output, err := os.OpenFile(task.path, flag, 0666)
+	file, err := os.OpenFile(task.path, flag, 0666)
 
 	if err != nil {
-		return errors.Wrapf(err, "failed to write file")
+		return 0, errors.Wrapf(err, "failed to write file")
 	}
-	defer output.Close()
+	defer file.Close()
+
+	// the offset of task.begin from the first byte of the part.
+	partBegin, _ := t.partRange(c.Parts, task.index)
+	var output io.Writer = file
 
-	var respReader io.Reader = resp.Body
+	if task.preallocated {
+		output = &checkpointWriter{
+			file:     file,
+			output:   io.NewOffsetWriter(file, task.begin),
+			manifest: manifest,
+			index:    task.index,
+			begin:    task.begin - partBegin,
+		}
+	}
+	if manifest.chain != nil {
+		output = manifest.chain.writer(task.index, task.begin-partBegin, output)
+	}
+
+	var respReader io.Reader = watcher.reader(resp.Body)
+	if task.end >= 0 {
+		// never takes more bytes than the part needs.
+		respReader = io.LimitReader(respReader, task.end-task.begin+1)
+	}
+	respReader = limitReader(req.Context(), respReader, t.limiter, t.sharedLimiter)
 	if prog != nil {
-		respReader = prog.reader(respReader)
+		respReader = prog.partReader(task.index, respReader)
 	}
 	written, err := io.Copy(output, respReader)
 
+	if written > 0 && (task.preallocated || isPausedContext(watcher.ctx)) {
+		// the blocks of the preallocated file, and the bytes resumed after pausing, must be saved before the manifest.
+		if syncErr := file.Sync(); syncErr != nil {
+			return written, errors.Wrapf(syncErr, "failed to flush file")
+		}
+	}
 	if err != nil {
-		return errors.Wrapf(err, "failed to write response body")
+		return written, errors.Wrapf(watcher.check(err), "failed to write response body")
+	}
+	if task.end < 0 {
+		// unknown content length, take whatever the server sends.
+		return written, nil
 	}
 	wantSize := task.end - task.begin + 1
 	if written < wantSize {
-		return errors.New("download bytes is less than expected")
+		return written, ContentLengthError{Expected: wantSize, Actual: written}
+	}
+	return written, nil
+}
+
+// a ranged request must get 206 with the same range, and a full request must get 200.
+func (t *GettingTask) checkResponse(req *http.Request, resp *http.Response, task *subTask, m *mirror) error {
+	if req.Header.Get("Range") == "" {
+		if resp.StatusCode != http.StatusOK {
+			return createHTTPStatusError(resp)
+		}
+		return nil
+	}
+	if err := m.checkRemoteUnchanged(resp); err != nil {
+		// the server responds 200 for the If-Range of an old version.
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return RangeNotSupportedError{createHTTPStatusError(resp)}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return createHTTPStatusError(resp)
+	}
+	contentRange := resp.Header.Get("Content-Range")
+	begin, end, total, err := parseContentRange(contentRange)
+
+	if err != nil || begin != task.begin || end != task.end || total != t.contentLength {
+		return ContentRangeError{
+			Begin:        task.begin,
+			End:          task.end,
+			Total:        t.contentLength,
+			ContentRange: contentRange,
+		}
 	}
 	return nil
 }
 
-func (t *GettingTask) mergeFile(c *GettingConfig, prog *progress) error {
+// checks the checksums computed while downloading, or reads the files if they are not available.
+// returns the digests computed by algorithm.
+func (t *GettingTask) verifyFile(c *GettingConfig, expected []expectedDigest, manifest *manifestFile, prog *progress) (map[string]string, error) {
+	digests := manifest.chain.sum()
+	if digests != nil {
+		prog.fireCompleted()
+	} else {
+		partPathList := []string{}
+		for _, partFile := range manifest.partFiles() {
+			partPathList = append(partPathList, filepath.Join(c.PartsPath, partFile))
+		}
+		var err error
+		digests, err = hashFiles(partPathList, algorithmsOf(expected), prog)
+		if err != nil {
+			return nil, createMergeError(err, "failed to get checksum")
+		}
+	}
+	return digests, checkDigests(expected, digests)
+}
+
+// merges the parts into the target file. if the signature is not nil, the merged file is verified
+// before it is moved to the target path.
+func (t *GettingTask) mergeFile(c *GettingConfig, manifest *manifestFile, prog *progress, signature []byte) error {
 	err := os.MkdirAll(c.dirPath(), 0755)
 	if err != nil {
-		return errors.Wrapf(err, "make directory failed")
+		return createMergeError(err, "make directory failed")
 	}
 	partPathList := []string{}
-	for i := 0; i < c.Parts; i++ {
-		partPath := filepath.Join(c.PartsPath, c.partFileName(i))
-		partPathList = append(partPathList, partPath)
+	for _, partFile := range manifest.partFiles() {
+		partPathList = append(partPathList, filepath.Join(c.PartsPath, partFile))
 	}
-	if c.SHA512 != "" {
-		code, err := sha512OfFiles(&partPathList)
-		if err != nil {
-			return errors.Wrapf(err, "failed to get sha512 code")
+	mergedPath := partPathList[0]
+
+	if len(partPathList) > 1 {
+		mergedPath = c.FilePath
+		if signature != nil {
+			mergedPath = filepath.Join(c.dirPath(), c.mergingFileName())
 		}
-		if code != c.SHA512 {
-			return createSHA512Error("sha512 code does not match")
+		if err := concatFiles(mergedPath, partPathList, prog); err != nil {
+			return err
 		}
 	}
-	if len(partPathList) == 1 {
-		partPath := partPathList[0]
-		err := os.Rename(partPath, c.FilePath)
-		if err != nil {
-			return errors.Wrapf(err, "failed to move file")
+	if signature != nil {
+		if err := verifySignature(c.Signature.Verifier, mergedPath, signature); err != nil {
+			return err
 		}
-	} else {
-		targetFile, err := os.Create(c.FilePath)
-		if err != nil {
-			return errors.Wrap(err, "failed to create a file in download location")
+	}
+	if mergedPath != c.FilePath {
+		if err := os.Rename(mergedPath, c.FilePath); err != nil {
+			return createMergeError(err, "failed to move file")
 		}
-		defer targetFile.Close()
+	}
+	t.cleanPartFiles(c)
+	return nil
+}
 
-		for _, partPath := range partPathList {
-			subFile, err := os.Open(partPath)
-			if err != nil {
-				return errors.Wrapf(err, "failed to open file in download location")
-			}
-			defer subFile.Close()
+func concatFiles(targetPath string, pathList []string, prog *progress) error {
+	targetFile, err := os.Create(targetPath)
+	if err != nil {
+		return createMergeError(err, "failed to create a file in download location")
+	}
+	defer targetFile.Close()
 
-			var reader io.Reader = subFile
-			if prog != nil {
-				reader = prog.reader(reader)
-			}
-			_, err = io.Copy(targetFile, reader)
-			if err != nil {
-				errors.Wrapf(err, "failed to copy part of file")
-			}
+	for _, partPath := range pathList {
+		subFile, err := os.Open(partPath)
+		if err != nil {
+			return createMergeError(err, "failed to open file in download location")
+		}
+		defer subFile.Close()
+
+		var reader io.Reader = subFile
+		if prog != nil {
+			reader = prog.reader(reader)
 		}
-		t.cleanPartFiles(c)
+		_, err = io.Copy(targetFile, reader)
+		if err != nil {
+			return createMergeError(err, "failed to copy part of file")
+		}
+	}
+	if err := targetFile.Close(); err != nil {
+		return createMergeError(err, "failed to write file in download location")
 	}
 	return nil
 }
 
 func (t *GettingTask) cleanPartFiles(c *GettingConfig) error {
+	paths := []string{
+		filepath.Join(c.PartsPath, c.manifestFileName()),
+		filepath.Join(c.PartsPath, c.preallocatedFileName()),
+		filepath.Join(c.dirPath(), c.mergingFileName()),
+	}
 	for i := 0; i < c.Parts; i++ {
-		partPath := filepath.Join(c.PartsPath, c.partFileName(i))
-		if err := os.Remove(partPath); err != nil {
+		paths = append(paths, filepath.Join(c.PartsPath, c.partFileName(i)))
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
 			if !os.IsNotExist(err) {
 				return err
 			}
@@ -257,38 +622,65 @@ func (t *GettingTask) cleanPartFiles(c *GettingConfig) error {
 }
 
 type subTask struct {
+	index        int
 	begin        int64
 	end          int64
 	path         string
 	overrideFile bool
+	preallocated bool
 }
 
-func (t *GettingTask) getPartTask(c *GettingConfig, index int) *subTask {
-	chunkSize := t.contentLength / int64(c.Parts)
-	begin := chunkSize * int64(index)
-	end := int64(0)
-
-	if index == c.Parts-1 {
-		end = t.contentLength - 1
+func (t *GettingTask) getPartTask(c *GettingConfig, manifest *manifestFile, index int) *subTask {
+	part := manifest.part(index)
+	task := &subTask{
+		index:        index,
+		begin:        part.Begin,
+		end:          part.End,
+		path:         filepath.Join(c.PartsPath, part.File),
+		preallocated: c.Storage == StoragePreallocated,
+	}
+	if task.preallocated {
+		task.begin += part.Completed
+	} else if part.End < 0 || !t.rangeable || part.Completed == 0 {
+		task.overrideFile = true
 	} else {
-		end = begin + chunkSize - 1
+		task.begin += part.Completed
+	}
+	if part.End >= 0 && task.begin > part.End {
+		return nil
 	}
-	filePath := filepath.Join(c.PartsPath, c.partFileName(index))
-	info, err := os.Stat(filePath)
-	overrideFile := false
+	return task
+}
 
+func (t *GettingTask) preallocateFile(c *GettingConfig) error {
+	file, err := os.OpenFile(filepath.Join(c.PartsPath, c.preallocatedFileName()), os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
-		overrideFile = true
-	} else {
-		begin += info.Size()
-		if begin > end {
-			return nil
+		return errors.Wrap(err, "failed to create preallocated file")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to create preallocated file")
+	}
+	if info.Size() != t.contentLength {
+		if err := file.Truncate(t.contentLength); err != nil {
+			return errors.Wrap(err, "failed to preallocate file")
 		}
 	}
-	return &subTask{
-		begin:        begin,
-		end:          end,
-		path:         filePath,
-		overrideFile: overrideFile,
+	return nil
+}
+
+// returns the first and the last byte of the part. the last byte is -1 if the content length is unknown.
+func (t *GettingTask) partRange(parts int, index int) (int64, int64) {
+	if t.contentLength < 0 {
+		return 0, -1
+	}
+	chunkSize := t.contentLength / int64(parts)
+	begin := chunkSize * int64(index)
+
+	if index == parts-1 {
+		return begin, t.contentLength - 1
 	}
+	return begin, begin + chunkSize - 1
 }
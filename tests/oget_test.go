@@ -1,16 +1,32 @@
 package oget_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/oomol-lab/oget"
 )
@@ -112,30 +128,1529 @@ func TestAll(t *testing.T) {
 		}
 		var lastEvent *oget.ProgressEvent = nil
 		var phaseCount int = 0
+		phaseOrders := map[oget.ProgressPhase]int{
+			oget.ProgressPhaseDownloading: 0,
+			oget.ProgressPhaseVerifying:   1,
+			oget.ProgressPhaseCoping:      2,
+			oget.ProgressPhaseDone:        3,
+		}
+		for _, event := range events {
+			if lastEvent != nil {
+				if phaseOrders[event.Phase] < phaseOrders[lastEvent.Phase] {
+					t.Fatalf("unexpected phase: %d", event.Phase)
+				}
+				if event.Phase == lastEvent.Phase && event.Progress < lastEvent.Progress {
+					t.Fatalf("unexpected progress: %d", event.Progress)
+				}
+				if event.Phase != lastEvent.Phase {
+					phaseCount = 0
+				}
+			}
+			lastEvent = &event
+			phaseCount += 1
+		}
+		if lastEvent == nil {
+			t.Fatalf("no progress event")
+		}
+		if lastEvent.Phase != oget.ProgressPhaseDone {
+			t.Fatalf("unexpected phase: %d", lastEvent.Phase)
+		}
+		if phaseCount != 1 {
+			t.Fatalf("unexpected phase count: %d", phaseCount)
+		}
+	})
+
+	t.Run("coalesce progress events", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fileURL,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		var calling atomic.Int32
+		listened := []oget.ProgressEvent{}
+		received := []oget.ProgressEvent{}
+		channel := make(chan oget.ProgressEvent)
+		receiving := make(chan struct{})
+
+		go func() {
+			defer close(receiving)
+			for event := range channel {
+				received = append(received, event)
+			}
+		}()
+		// the channel is reused by the calls, and closed by its owner.
+		for _, name := range []string{"target-coalesced.bin", "target-coalesced-again.bin"} {
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:         filepath.Join(outputPath, name),
+				PartsPath:        partsPath,
+				Parts:            4,
+				SHA512:           sha512Code,
+				ProgressInterval: time.Hour,
+				ProgressChannel:  channel,
+				ListenProgress: func(event oget.ProgressEvent) {
+					if calling.Add(1) != 1 {
+						t.Errorf("listener is called concurrently")
+					}
+					time.Sleep(time.Millisecond)
+					listened = append(listened, event)
+					calling.Add(-1)
+				},
+			})
+			if err != nil {
+				t.Fatalf("download file: %s", err)
+			}
+		}
+		close(channel)
+		<-receiving
+
+		if len(listened) != len(received) {
+			t.Fatalf("unexpected events: %d listened, %d received", len(listened), len(received))
+		}
+		downloading := []oget.ProgressEvent{}
+		for _, event := range listened {
+			if event.Phase == oget.ProgressPhaseDownloading {
+				downloading = append(downloading, event)
+			}
+		}
+		// only the first and the last events of the phase are delivered within the interval.
+		if len(downloading) != 4 {
+			t.Fatalf("unexpected downloading events: %d", len(downloading))
+		}
+		if downloading[1].Progress != fileLength || downloading[3].Progress != fileLength {
+			t.Fatalf("unexpected last progress: %+v", downloading)
+		}
+		if last := listened[len(listened)-1]; last.Phase != oget.ProgressPhaseDone {
+			t.Fatalf("unexpected last phase: %d", last.Phase)
+		}
+	})
+
+	t.Run("stop receiving progress after canceling", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			Context: ctx,
+			URL:     fmt.Sprintf("%s/target_hanging.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		// nobody receives the channel, Get returns once the context is done.
+		time.AfterFunc(100*time.Millisecond, cancel)
+		beginTime := time.Now()
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:        filepath.Join(outputPath, "target-unreceived.bin"),
+			PartsPath:       partsPath,
+			Parts:           2,
+			ProgressChannel: make(chan oget.ProgressEvent),
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if elapsed := time.Since(beginTime); elapsed > time.Second {
+			t.Fatalf("get is blocked by the channel: %s", elapsed)
+		}
+	})
+
+	t.Run("retry timeouts of the library only", func(t *testing.T) {
+		slowURL := fmt.Sprintf("%s/target_slow.bin", server.URL)
+		_, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL:     slowURL,
+			Timeout: 50 * time.Millisecond,
+		})
+		if err == nil || !oget.IsRetryable(err) || oget.ErrorKindOf(err) != oget.ErrorKindTimeout {
+			t.Fatalf("timeout of probing should be retryable: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err = oget.CreateGettingTask(&oget.RemoteFile{
+			Context: ctx,
+			URL:     slowURL,
+		})
+		if err == nil || oget.IsRetryable(err) || oget.ErrorKindOf(err) != oget.ErrorKindTimeout {
+			t.Fatalf("deadline of the caller should not be retryable: %v", err)
+		}
+	})
+
+	t.Run("probe with ranged get request", func(t *testing.T) {
+		noHeadURL := fmt.Sprintf("%s/target_no_head.bin", server.URL)
+		_, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL:   noHeadURL,
+			Probe: oget.ProbeHead,
+		})
+		if err == nil {
+			t.Fatalf("head request should fail")
+		}
+		for _, probe := range []oget.ProbeMethod{oget.ProbeHeadThenGet, oget.ProbeGet} {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL:   noHeadURL,
+				Probe: probe,
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			if task.ContentLength() != fileLength {
+				t.Fatalf("unexpected content length: %d", task.ContentLength())
+			}
+			if !task.SupportsRange() {
+				t.Fatalf("unexpected range support")
+			}
+		}
+	})
+
+	t.Run("download without range support", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/target_no_range.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		if task.SupportsRange() {
+			t.Fatalf("unexpected range support")
+		}
+		savedFilePath := filepath.Join(outputPath, "target-no-range.bin")
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			SHA512:    sha512Code,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		savedFileCode, err := oget.SHA512(savedFilePath)
+
+		if err != nil {
+			t.Fatalf("get code of sha512 fail: %s", err)
+		}
+		if sha512Code != savedFileCode {
+			t.Fatalf("unexpected sha512 code: %s", savedFileCode)
+		}
+	})
+
+	t.Run("download with unknown content length", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/target_chunked.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		if task.ContentLength() != -1 {
+			t.Fatalf("unexpected content length: %d", task.ContentLength())
+		}
+		var mux sync.Mutex
+		savedFilePath := filepath.Join(outputPath, "target-chunked.bin")
+		events := []oget.ProgressEvent{}
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			SHA512:    sha512Code,
+			ListenProgress: func(event oget.ProgressEvent) {
+				mux.Lock()
+				events = append(events, event)
+				mux.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		if len(events) == 0 {
+			t.Fatalf("no progress event")
+		}
+		if events[0].Phase != oget.ProgressPhaseDownloading || events[0].Total != -1 {
+			t.Fatalf("unexpected first event: %+v", events[0])
+		}
+		lastEvent := events[len(events)-1]
+		if lastEvent.Phase != oget.ProgressPhaseDone || lastEvent.Total != fileLength {
+			t.Fatalf("unexpected last event: %+v", lastEvent)
+		}
+	})
+
+	t.Run("download empty file", func(t *testing.T) {
+		emptySHA512 := fmt.Sprintf("%x", sha512.Sum512(nil))
+		for _, probe := range []oget.ProbeMethod{oget.ProbeHeadThenGet, oget.ProbeHead, oget.ProbeGet} {
+			for _, name := range []string{"empty.bin", "empty_unsatisfiable.bin"} {
+				task, err := oget.CreateGettingTask(&oget.RemoteFile{
+					URL:   fmt.Sprintf("%s/%s", server.URL, name),
+					Probe: probe,
+				})
+				if err != nil {
+					t.Fatalf("create task fail: %s", err)
+				}
+				if task.ContentLength() != 0 {
+					t.Fatalf("unexpected content length of %s probed by %d: %d", name, probe, task.ContentLength())
+				}
+				savedFilePath := filepath.Join(outputPath, fmt.Sprintf("empty-%d.bin", probe))
+				_, err = task.Get(&oget.GettingConfig{
+					FilePath:  savedFilePath,
+					PartsPath: partsPath,
+					Parts:     4,
+					SHA512:    emptySHA512,
+					Chunks:    &oget.Chunks{Algorithm: "sha256", Size: 4096},
+				})
+				if err != nil {
+					t.Fatalf("download file: %s", err)
+				}
+				info, err := os.Stat(savedFilePath)
+				if err != nil || info.Size() != 0 {
+					t.Fatalf("unexpected file: %v", err)
+				}
+			}
+		}
+	})
+
+	t.Run("download with retry policy", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/target_flaky.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		var mux sync.Mutex
+		savedFilePath := filepath.Join(outputPath, "target-flaky.bin")
+		retryEvents := []oget.RetryEvent{}
+		retries := 0
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			SHA512:    sha512Code,
+			Retry: oget.RetryPolicy{
+				MaxAttempts: 3,
+				BaseBackoff: time.Millisecond,
+				Jitter:      0.5,
+			},
+			ListenProgress: func(event oget.ProgressEvent) {
+				mux.Lock()
+				retries = event.Retries
+				mux.Unlock()
+			},
+			ListenRetry: func(event oget.RetryEvent) {
+				mux.Lock()
+				retryEvents = append(retryEvents, event)
+				mux.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		if len(retryEvents) != 4 {
+			t.Fatalf("unexpected retry count: %d", len(retryEvents))
+		}
+		if retries != 4 {
+			t.Fatalf("unexpected retries of progress: %d", retries)
+		}
+		for _, event := range retryEvents {
+			if event.Attempt != 2 || event.Err == nil {
+				t.Fatalf("unexpected retry event: %+v", event)
+			}
+		}
+	})
+
+	t.Run("server ignores range request", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/target_ignore_range.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		savedFilePath := filepath.Join(outputPath, "target-ignore-range.bin")
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     2,
+		})
+		var rangeError oget.RangeNotSupportedError
+		if !errors.As(err, &rangeError) || rangeError.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if oget.IsRetryable(err) {
+			t.Fatalf("error should not be retryable: %s", err)
+		}
+		if _, err := os.Stat(savedFilePath); !os.IsNotExist(err) {
+			t.Fatalf("file should not be saved")
+		}
+	})
+
+	t.Run("resume with manifest", func(t *testing.T) {
+		resumeURL := fmt.Sprintf("%s/target_flaky_resume.bin", server.URL)
+		savedFilePath := filepath.Join(outputPath, "target-resume.bin")
+		manifestPath := filepath.Join(partsPath, "target-resume.bin.manifest.json")
+
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: resumeURL,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     2,
+			SHA512:    sha512Code,
+		})
+		var lengthError oget.ContentLengthError
+		if !errors.As(err, &lengthError) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		manifest, err := oget.LoadManifest(manifestPath)
+		if err != nil {
+			t.Fatalf("load manifest fail: %s", err)
+		}
+		if manifest.URL != resumeURL || manifest.ContentLength != fileLength || len(manifest.Parts) != 2 {
+			t.Fatalf("unexpected manifest: %+v", manifest)
+		}
+		if manifest.Hash == nil || manifest.Hash.States["sha512"] == nil || manifest.Hash.Part != 0 {
+			t.Fatalf("unexpected hash state: %+v", manifest.Hash)
+		}
+		completed := int64(0)
+		for _, part := range manifest.Parts {
+			if part.Completed > (part.End-part.Begin+1)/2 {
+				t.Fatalf("unexpected completed bytes: %+v", part)
+			}
+			completed += part.Completed
+		}
+		if completed == 0 {
+			t.Fatalf("no completed bytes")
+		}
+		// resumes with the layout of the manifest, even if the number of parts changes.
+		// the part cancelled before its first request will be interrupted again.
+		var mux sync.Mutex
+		events := []oget.ProgressEvent{}
+
+		result, err := task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			SHA512:    sha512Code,
+			Retry:     oget.RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+			ListenProgress: func(event oget.ProgressEvent) {
+				mux.Lock()
+				defer mux.Unlock()
+				if event.Phase == oget.ProgressPhaseDownloading {
+					events = append(events, event)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+			t.Fatalf("manifest should be removed")
+		}
+		// the progress continues from the bytes saved by the first attempt.
+		if events[0].Resumed != completed || events[0].Progress <= completed {
+			t.Fatalf("unexpected first event: %+v", events[0])
+		}
+		for _, event := range events {
+			part := manifest.Parts[event.Part]
+			if event.PartOffset != part.Begin || event.PartLength != part.End-part.Begin+1 ||
+				event.PartProgress <= part.Completed || event.PartProgress > event.PartLength {
+				t.Fatalf("unexpected part of event: %+v", event)
+			}
+		}
+		lastEvent := events[len(events)-1]
+		if lastEvent.Progress != fileLength || lastEvent.ETA != 0 || lastEvent.AverageSpeed <= 0 {
+			t.Fatalf("unexpected last event: %+v", lastEvent)
+		}
+		if result.FilePath != savedFilePath || result.FinalURL != resumeURL ||
+			result.Resumed != completed || result.Downloaded != fileLength-completed || result.Retries != lastEvent.Retries {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		if result.ProbeDuration <= 0 || result.DownloadDuration <= 0 || result.VerifyDuration <= 0 ||
+			result.MergeDuration <= 0 || result.AverageSpeed <= 0 {
+			t.Fatalf("unexpected durations of result: %+v", result)
+		}
+		if len(result.Digests) != 1 || result.Digests[0] != (oget.Checksum{Algorithm: "sha512", Digest: sha512Code}) {
+			t.Fatalf("unexpected digests of result: %+v", result.Digests)
+		}
+	})
+
+	t.Run("download into preallocated file", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/target_flaky_preallocated.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		savedFilePath := filepath.Join(outputPath, "target-preallocated.bin")
+		downloadingPath := filepath.Join(partsPath, "target-preallocated.bin.downloading")
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			Storage:   oget.StoragePreallocated,
+		})
+		var lengthError oget.ContentLengthError
+		if !errors.As(err, &lengthError) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		info, err := os.Stat(downloadingPath)
+		if err != nil || info.Size() != fileLength {
+			t.Fatalf("file should be preallocated: %s", err)
+		}
+		manifest, err := oget.LoadManifest(filepath.Join(partsPath, "target-preallocated.bin.manifest.json"))
+		if err != nil {
+			t.Fatalf("load manifest fail: %s", err)
+		}
+		if !manifest.Preallocated || manifest.BlockSize <= 0 || len(manifest.Parts) != 4 {
+			t.Fatalf("unexpected manifest: %+v", manifest)
+		}
+		completedBlocks := 0
+		for _, part := range manifest.Parts {
+			if part.File != "target-preallocated.bin.downloading" {
+				t.Fatalf("unexpected part file: %s", part.File)
+			}
+			if len(part.Blocks) > 0 && part.Blocks[0] != 0 {
+				completedBlocks += 1
+			}
+		}
+		if completedBlocks == 0 {
+			t.Fatalf("no completed blocks")
+		}
+		events := []oget.ProgressEvent{}
+		var mux sync.Mutex
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     4,
+			Storage:   oget.StoragePreallocated,
+			SHA512:    sha512Code,
+			Retry:     oget.RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+			ListenProgress: func(event oget.ProgressEvent) {
+				mux.Lock()
+				events = append(events, event)
+				mux.Unlock()
+			},
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		verifyingEvents := 0
+		for _, event := range events {
+			if event.Phase == oget.ProgressPhaseCoping {
+				t.Fatalf("unexpected coping phase")
+			}
+			if event.Phase == oget.ProgressPhaseVerifying {
+				verifyingEvents += 1
+			}
+		}
+		if verifyingEvents == 0 {
+			t.Fatalf("no verifying phase")
+		}
+		if _, err := os.Stat(downloadingPath); !os.IsNotExist(err) {
+			t.Fatalf("preallocated file should be moved")
+		}
+	})
+
+	t.Run("save blocks of preallocated file while downloading", func(t *testing.T) {
+		savedFilePath := filepath.Join(outputPath, "target-hanging.bin")
+		manifestPath := filepath.Join(partsPath, "target-hanging.bin.manifest.json")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			Context: ctx,
+			URL:     fmt.Sprintf("%s/target_hanging.bin", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := task.Get(&oget.GettingConfig{
+				FilePath:  savedFilePath,
+				PartsPath: partsPath,
+				Storage:   oget.StoragePreallocated,
+			})
+			errChan <- err
+		}()
+		// the blocks are saved before the attempt ends, as if the process crashes.
+		var part oget.ManifestPart
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			manifest, err := oget.LoadManifest(manifestPath)
+			if err == nil && manifest.Parts[0].Completed > 0 {
+				part = manifest.Parts[0]
+				break
+			}
+		}
+		cancel()
+		if err := <-errChan; !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if part.Completed < 16*4096 || len(part.Blocks) != 3 || part.Blocks[0] != 0xff || part.Blocks[1] != 0xff {
+			t.Fatalf("unexpected part of manifest: %+v", part)
+		}
+	})
+
+	t.Run("discard parts of changed remote file", func(t *testing.T) {
+		changingURL := fmt.Sprintf("%s/target_changing.bin", server.URL)
+		savedFilePath := filepath.Join(outputPath, "target-changing.bin")
+		changingETag.Store(`"v1"`)
+
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: changingURL,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		if task.ETag() != `"v1"` {
+			t.Fatalf("unexpected etag: %s", task.ETag())
+		}
+		changingETag.Store(`"v2"`)
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     2,
+		})
+		var changedError oget.RemoteChangedError
+		if !errors.As(err, &changedError) || changedError.Actual != `"v2"` {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// leaves stale parts of v1 to be discarded.
+		err = os.WriteFile(filepath.Join(partsPath, "target-changing.bin.2.0.downloading"), []byte("stale"), 0666)
+		if err != nil {
+			t.Fatalf("write stale part fail: %s", err)
+		}
+		manifest, err := json.Marshal(&oget.Manifest{
+			Version:       1,
+			URL:           changingURL,
+			ContentLength: fileLength,
+			ETag:          `"v1"`,
+			Parts: []oget.ManifestPart{
+				{File: "target-changing.bin.2.0.downloading", Begin: 0, End: fileLength/2 - 1, Completed: 5},
+				{File: "target-changing.bin.2.1.downloading", Begin: fileLength / 2, End: fileLength - 1},
+			},
+		})
+		if err != nil {
+			t.Fatalf("encode manifest fail: %s", err)
+		}
+		err = os.WriteFile(filepath.Join(partsPath, "target-changing.bin.manifest.json"), manifest, 0666)
+		if err != nil {
+			t.Fatalf("write manifest fail: %s", err)
+		}
+		task, err = oget.CreateGettingTask(&oget.RemoteFile{
+			URL: changingURL,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		retryEvents := []oget.RetryEvent{}
+
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     2,
+			SHA512:    sha512Code,
+			ListenRetry: func(event oget.RetryEvent) {
+				retryEvents = append(retryEvents, event)
+			},
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		if len(retryEvents) != 1 || retryEvents[0].Part != -1 || !errors.As(retryEvents[0].Err, &changedError) {
+			t.Fatalf("unexpected retry events: %+v", retryEvents)
+		}
+	})
+
+	t.Run("resume parts without manifest", func(t *testing.T) {
+		savedFilePath := filepath.Join(outputPath, "target-legacy.bin")
+		content, err := os.ReadFile("./target.bin")
+		if err != nil {
+			t.Fatalf("read file fail: %s", err)
+		}
+		// the parts left by the previous releases, the second of which is foreign.
+		err = os.WriteFile(filepath.Join(partsPath, "target-legacy.bin.2.0.downloading"), content[:fileLength/4], 0666)
+		if err != nil {
+			t.Fatalf("write part fail: %s", err)
+		}
+		err = os.WriteFile(filepath.Join(partsPath, "target-legacy.bin.2.1.downloading"), content, 0666)
+		if err != nil {
+			t.Fatalf("write part fail: %s", err)
+		}
+		result, err := (&oget.OGet{
+			URL:       fileURL,
+			FilePath:  savedFilePath,
+			PartsPath: partsPath,
+			Parts:     2,
+			SHA512:    sha512Code,
+		}).Get()
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		if result.Resumed != fileLength/4 || result.Downloaded != fileLength-fileLength/4 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("remove manifest within its directory", func(t *testing.T) {
+		manifestDir := filepath.Join(partsPath, "removing")
+		if err := os.MkdirAll(manifestDir, 0755); err != nil {
+			t.Fatalf("create directory fail: %s", err)
+		}
+		outsidePath := filepath.Join(partsPath, "outside.bin")
+		partPath := filepath.Join(manifestDir, "target-removing.bin.2.1.downloading")
+
+		for _, path := range []string{outsidePath, partPath} {
+			if err := os.WriteFile(path, []byte("part"), 0666); err != nil {
+				t.Fatalf("write file fail: %s", err)
+			}
+		}
+		manifest, err := json.Marshal(&oget.Manifest{
+			Version:       1,
+			URL:           fileURL,
+			ContentLength: fileLength,
+			Parts: []oget.ManifestPart{
+				{File: "../outside.bin", Begin: 0, End: fileLength/2 - 1},
+				{File: "target-removing.bin.2.1.downloading", Begin: fileLength / 2, End: fileLength - 1},
+			},
+		})
+		if err != nil {
+			t.Fatalf("encode manifest fail: %s", err)
+		}
+		manifestPath := filepath.Join(manifestDir, "target-removing.bin.manifest.json")
+		if err := os.WriteFile(manifestPath, manifest, 0666); err != nil {
+			t.Fatalf("write manifest fail: %s", err)
+		}
+		if err := oget.RemoveManifest(manifestPath); err != nil {
+			t.Fatalf("remove manifest fail: %s", err)
+		}
+		if _, err := os.Stat(outsidePath); err != nil {
+			t.Fatalf("file out of the directory should be kept: %s", err)
+		}
+		for _, path := range []string{partPath, manifestPath} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("%s should be removed", path)
+			}
+		}
+	})
+
+	t.Run("download with checksums", func(t *testing.T) {
+		tryDownload := func(checksums []oget.Checksum, sha512 string) error {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: fileURL,
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, "target-checksums.bin"),
+				PartsPath: partsPath,
+				Parts:     3,
+				SHA512:    sha512,
+				Checksums: checksums,
+			})
+			return err
+		}
+		err := tryDownload([]oget.Checksum{
+			{Algorithm: "SHA-256", Digest: "477B7109162D1CABC828BD6AF1C49CB77BFE199CA1A5C165F981986B6302767F"},
+			{Algorithm: "md5", Digest: "ad3e8d1d5bcec177e7215e344a451ec6"},
+			{Algorithm: "blake2b-512", Digest: "3dddd1db0b4f98a4d3459349decd740695d9836a3395e775141404f792400bd0841e78fc80c2ab3ab949511c11cbc4a7b9649fd9936423618c4d9110ffd876c1"},
+		}, sha512Code)
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		var checksumError oget.ChecksumError
+		err = tryDownload([]oget.Checksum{{Algorithm: "sha1", Digest: "0000000000000000000000000000000000000000"}}, "")
+
+		if !errors.As(err, &checksumError) || checksumError.Algorithm != "sha1" || oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var sha512Error oget.SHA512Error
+		err = tryDownload(nil, strings.Repeat("0", 128))
+
+		if !errors.As(err, &sha512Error) || !errors.As(err, &checksumError) || checksumError.Actual != sha512Code {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var configError oget.ConfigError
+		err = tryDownload([]oget.Checksum{{Algorithm: "unknown", Digest: "00"}}, "")
+
+		if !errors.As(err, &configError) || oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		err = tryDownload(nil, "not hex")
+
+		if !errors.As(err, &configError) || oget.ErrorKindOf(err) != oget.ErrorKindConfig {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		oget.RegisterHash("length", func() hash.Hash { return &lengthHash{} })
+		err = tryDownload([]oget.Checksum{{Algorithm: "length", Digest: fmt.Sprintf("%016x", fileLength)}}, "")
+
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+	})
+
+	t.Run("download with integrity", func(t *testing.T) {
+		sha512Integrity := "sha512-0ob7sfq5AU/bxUPQn1TLk9puDyyAnmLuDIHWnkv1juxEVx+uGSqNqbx3LOE0Cg1RrWOM26YRiQm1VaErAF8pMA=="
+		sha256Integrity := "sha256-R3txCRYtHKvIKL1q8cSct3v+GZyhpcFl+YGYa2MCdn8="
+		wrongIntegrity := "sha512-" + strings.Repeat("A", 86) + "=="
+
+		integrity, err := oget.SRI("./target.bin", "sha256", "sha512")
+		if err != nil {
+			t.Fatalf("get integrity fail: %s", err)
+		}
+		if integrity != sha256Integrity+" "+sha512Integrity {
+			t.Fatalf("unexpected integrity: %s", integrity)
+		}
+		tryDownload := func(integrity string) error {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: fileURL,
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, "target-integrity.bin"),
+				PartsPath: partsPath,
+				Parts:     2,
+				Integrity: integrity,
+			})
+			return err
+		}
+		// only the strongest algorithm is checked, and any of its tokens may match.
+		for _, integrity := range []string{
+			sha512Integrity,
+			"sha256-" + strings.Repeat("A", 43) + "= " + sha512Integrity + "?opt",
+			wrongIntegrity + " " + sha512Integrity,
+		} {
+			if err := tryDownload(integrity); err != nil {
+				t.Fatalf("download file with %q: %s", integrity, err)
+			}
+		}
+		var checksumError oget.ChecksumError
+		err = tryDownload(sha256Integrity + " " + wrongIntegrity)
+
+		if !errors.As(err, &checksumError) || checksumError.Algorithm != "sha512" {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tryDownload("md5-abc"); err == nil {
+			t.Fatalf("integrity without supported algorithms must fail")
+		}
+	})
+
+	t.Run("verify server digests", func(t *testing.T) {
+		tryDownload := func(name string, verify bool) (*oget.GettingTask, error) {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: fmt.Sprintf("%s/%s", server.URL, name),
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:           filepath.Join(outputPath, "target-digest.bin"),
+				PartsPath:          partsPath,
+				Parts:              2,
+				VerifyServerDigest: verify,
+			})
+			return task, err
+		}
+		task, err := tryDownload("target_digest.bin", true)
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		algorithms := []string{}
+		for _, digest := range task.Digests() {
+			algorithms = append(algorithms, digest.Algorithm)
+			if digest.Algorithm == "sha512" && digest.Digest != sha512Code {
+				t.Fatalf("unexpected digest: %+v", digest)
+			}
+		}
+		if strings.Join(algorithms, ",") != "sha256,crc32c,md5,sha512" {
+			t.Fatalf("unexpected digests: %+v", task.Digests())
+		}
+		if _, err := tryDownload("target_wrong_digest.bin", false); err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		var checksumError oget.ChecksumError
+		_, err = tryDownload("target_wrong_digest.bin", true)
+
+		if !errors.As(err, &checksumError) || checksumError.Algorithm != "sha512" || checksumError.Actual != sha512Code {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("discover checksum file", func(t *testing.T) {
+		tryDownload := func(url string, checksumFile *oget.ChecksumFile) error {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: url,
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:     filepath.Join(outputPath, "target-checksum-file.bin"),
+				PartsPath:    partsPath,
+				ChecksumFile: checksumFile,
+			})
+			return err
+		}
+		err := tryDownload(fmt.Sprintf("%s/release/target.bin", server.URL), &oget.ChecksumFile{Required: true})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		err = tryDownload(fileURL, &oget.ChecksumFile{
+			URL:      fmt.Sprintf("%s/sums/target.b2", server.URL),
+			Required: true,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		var checksumError oget.ChecksumError
+		err = tryDownload(fmt.Sprintf("%s/release/bad/target.bin", server.URL), &oget.ChecksumFile{Required: true})
+
+		if !errors.As(err, &checksumError) || checksumError.Algorithm != "sha512" {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var notFoundError oget.ChecksumNotFoundError
+		err = tryDownload(fileURL, &oget.ChecksumFile{Required: true})
+
+		if !errors.As(err, &notFoundError) || notFoundError.Name != "target.bin" || len(notFoundError.URLs) != 8 {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tryDownload(fileURL, &oget.ChecksumFile{}); err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		// the failures of the guessed files are skipped, unless the checksum is required.
+		if err := tryDownload(fmt.Sprintf("%s/forbidden/target.bin", server.URL), &oget.ChecksumFile{}); err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		var statusError oget.HTTPStatusError
+		err = tryDownload(fmt.Sprintf("%s/forbidden/target.bin", server.URL), &oget.ChecksumFile{Required: true})
+
+		if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusForbidden || oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		err = tryDownload(fmt.Sprintf("%s/release/unavailable/target.bin", server.URL), &oget.ChecksumFile{
+			URL: fmt.Sprintf("%s/release/unavailable/target.bin.sha512", server.URL),
+		})
+		if !errors.As(err, &statusError) || statusError.StatusCode != http.StatusServiceUnavailable || !oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// the query signed for the file is not sent for the checksum files.
+		err = tryDownload(fmt.Sprintf("%s/release/target.bin?signature=file", server.URL), &oget.ChecksumFile{Required: true})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+	})
+
+	t.Run("verify signature", func(t *testing.T) {
+		privateKey := testSigningKey()
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+		keyID := []byte("oget-key")
+
+		tryDownload := func(name string, signature *oget.Signature) error {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: fileURL,
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, name),
+				PartsPath: partsPath,
+				Parts:     2,
+				Signature: signature,
+			})
+			return err
+		}
+		err := tryDownload("target-ed25519.bin", &oget.Signature{
+			Verifier: oget.Ed25519Verifier{PublicKey: publicKey},
+			URL:      fmt.Sprintf("%s/target.bin.sig", server.URL),
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		verifier, err := oget.NewMinisignVerifier(fmt.Sprintf(
+			"untrusted comment: minisign public key\n%s\n",
+			base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...)),
+		))
+		if err != nil {
+			t.Fatalf("create minisign verifier fail: %s", err)
+		}
+		// the prehashed signature of minisign.
+		digest, err := oget.HashFile("./target.bin", "blake2b512")
+		if err != nil {
+			t.Fatalf("get blake2b fail: %s", err)
+		}
+		digestBytes, _ := hex.DecodeString(digest)
+		signature := ed25519.Sign(privateKey, digestBytes)
+		trustedComment := "timestamp:0\tfile:target.bin"
+		minisign := func(trustedComment string) []byte {
+			return []byte(fmt.Sprintf(
+				"untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+				base64.StdEncoding.EncodeToString(append(append([]byte("ED"), keyID...), signature...)),
+				trustedComment,
+				base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, append(signature, trustedComment...))),
+			))
+		}
+		err = tryDownload("target-minisign.bin", &oget.Signature{
+			Verifier: verifier,
+			Data:     minisign(trustedComment),
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		var signatureError oget.SignatureError
+		forged := bytes.Replace(minisign(trustedComment), []byte("timestamp:0"), []byte("timestamp:1"), 1)
+		err = tryDownload("target-forged.bin", &oget.Signature{
+			Verifier: verifier,
+			Data:     forged,
+		})
+		if !errors.As(err, &signatureError) || oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := os.Stat(filepath.Join(outputPath, "target-forged.bin")); !os.IsNotExist(err) {
+			t.Fatalf("file with invalid signature must not be moved to the file path")
+		}
+		// the legacy signature of minisign signs the whole file, which is read into the memory up to the limit.
+		content, err := os.ReadFile("./target.bin")
+		if err != nil {
+			t.Fatalf("read file fail: %s", err)
+		}
+		legacySignature := ed25519.Sign(privateKey, content)
+		legacy := []byte(fmt.Sprintf(
+			"untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+			base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), legacySignature...)),
+			trustedComment,
+			base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, append(legacySignature, trustedComment...))),
+		))
+		err = tryDownload("target-legacy.bin", &oget.Signature{
+			Verifier: verifier,
+			Data:     legacy,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		verifier.MaxLegacySize = int64(len(content) - 1)
+		err = tryDownload("target-legacy-large.bin", &oget.Signature{
+			Verifier: verifier,
+			Data:     legacy,
+		})
+		if !errors.As(err, &signatureError) || !strings.Contains(err.Error(), "prehashed") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		err = tryDownload("target-ed25519-large.bin", &oget.Signature{
+			Verifier: oget.Ed25519Verifier{PublicKey: publicKey, MaxSize: int64(len(content) - 1)},
+			URL:      fmt.Sprintf("%s/target.bin.sig", server.URL),
+		})
+		if !errors.As(err, &signatureError) || !strings.Contains(err.Error(), "prehashed") {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+
+	t.Run("repair corrupted chunks", func(t *testing.T) {
+		chunks := &oget.Chunks{
+			URL: fmt.Sprintf("%s/target.bin.chunks", server.URL),
+		}
+		// the chunk is repaired once.
+		retry := oget.RetryPolicy{MaxAttempts: 2, BaseBackoff: 10 * time.Millisecond}
+		tryDownload := func(name string, storage oget.StorageMode, chunks *oget.Chunks, retry oget.RetryPolicy) error {
+			task, err := oget.CreateGettingTask(&oget.RemoteFile{
+				URL: fmt.Sprintf("%s/%s", server.URL, name),
+			})
+			if err != nil {
+				t.Fatalf("create task fail: %s", err)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, "target-chunks.bin"),
+				PartsPath: partsPath,
+				Parts:     4,
+				Storage:   storage,
+				SHA512:    sha512Code,
+				Chunks:    chunks,
+				Retry:     retry,
+			})
+			return err
+		}
+		var sha512Error oget.SHA512Error
+		if err := tryDownload("target_corrupt.bin", oget.StoragePartFiles, nil, retry); !errors.As(err, &sha512Error) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// the default policy verifies the chunks without repairing them.
+		var checksumError oget.ChecksumError
+		if err := tryDownload("target_corrupt_unrepaired.bin", oget.StoragePartFiles, chunks, oget.RetryPolicy{}); !errors.As(err, &checksumError) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// a failed repair takes an attempt as well.
+		var statusError oget.HTTPStatusError
+		if err := tryDownload("target_corrupt_flaky.bin", oget.StoragePartFiles, chunks, retry); !errors.As(err, &statusError) || statusError.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tryDownload("target_corrupt_flaky_retried.bin", oget.StoragePartFiles, chunks, oget.RetryPolicy{MaxAttempts: 3, BaseBackoff: 10 * time.Millisecond}); err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		for _, name := range []string{"target_corrupt_chunks.bin", "target_corrupt_preallocated.bin"} {
+			storage := oget.StoragePartFiles
+			if name == "target_corrupt_preallocated.bin" {
+				storage = oget.StoragePreallocated
+			}
+			if err := tryDownload(name, storage, chunks, retry); err != nil {
+				t.Fatalf("download file: %s", err)
+			}
+			// only the first chunk is downloaded again.
+			req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s", server.URL, name), nil)
+			req.Header.Set("Range", "bytes=0-4095")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request fail: %s", err)
+			}
+			resp.Body.Close()
+
+			if resp.Header.Get("X-Range-Count") != "2" {
+				t.Fatalf("unexpected count of repairing: %s", resp.Header.Get("X-Range-Count"))
+			}
+		}
+		// the chunk is repaired from another mirror, which does not take the only repair.
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL:     fmt.Sprintf("%s/mirror/corrupt/target.bin", server.URL),
+			Mirrors: []string{fileURL},
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  filepath.Join(outputPath, "target-chunks-mirror.bin"),
+			PartsPath: partsPath,
+			Storage:   oget.StoragePreallocated,
+			SHA512:    sha512Code,
+			Retry:     retry,
+			Chunks:    chunks,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		stats := task.MirrorStats()
+		if len(stats) != 2 || stats[0].Failures != 1 || stats[1].Failures != 0 || stats[1].Bytes == 0 {
+			t.Fatalf("unexpected stats of mirrors: %+v", stats)
+		}
+	})
+
+	t.Run("limit bandwidth", func(t *testing.T) {
+		download := func(name string, remoteFile *oget.RemoteFile, started func(task *oget.GettingTask)) {
+			remoteFile.URL = fileURL
+			task, err := oget.CreateGettingTask(remoteFile)
+			if err != nil {
+				t.Errorf("create task fail: %s", err)
+				return
+			}
+			if started != nil {
+				started(task)
+			}
+			_, err = task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, name),
+				PartsPath: partsPath,
+				Parts:     2,
+				SHA512:    sha512Code,
+			})
+			if err != nil {
+				t.Errorf("download file: %s", err)
+			}
+		}
+		beginTime := time.Now()
+		download("target-limited.bin", &oget.RemoteFile{RateLimit: 256 * 1024, RateBurst: 16 * 1024}, nil)
+
+		if elapsed := time.Since(beginTime); elapsed < 150*time.Millisecond {
+			t.Fatalf("download is not limited: %s", elapsed)
+		}
+		// the limit is lifted while downloading, which would take 8 seconds.
+		beginTime = time.Now()
+		download("target-lifted.bin", &oget.RemoteFile{RateLimit: 8 * 1024}, func(task *oget.GettingTask) {
+			time.AfterFunc(100*time.Millisecond, func() {
+				task.SetRateLimit(0, 0)
+			})
+		})
+		if elapsed := time.Since(beginTime); elapsed > 3*time.Second {
+			t.Fatalf("limit is not lifted: %s", elapsed)
+		}
+		// two tasks share the limiter.
+		var wg sync.WaitGroup
+		limiter := oget.NewRateLimiter(512*1024, 16*1024)
+		beginTime = time.Now()
+
+		for _, name := range []string{"target-shared-1.bin", "target-shared-2.bin"} {
+			name := name
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				download(name, &oget.RemoteFile{RateLimiter: limiter}, nil)
+			}()
+		}
+		wg.Wait()
+
+		if elapsed := time.Since(beginTime); elapsed < 150*time.Millisecond {
+			t.Fatalf("download is not limited: %s", elapsed)
+		}
+		// the zero value is unlimited until its limit is set.
+		var zeroLimiter oget.RateLimiter
+		beginTime = time.Now()
+		download("target-zero-limiter.bin", &oget.RemoteFile{RateLimiter: &zeroLimiter}, func(task *oget.GettingTask) {
+			zeroLimiter.SetLimit(256*1024, 16*1024)
+		})
+		if limit, burst := zeroLimiter.Limit(); limit != 256*1024 || burst != 16*1024 {
+			t.Fatalf("unexpected limit: %d, %d", limit, burst)
+		}
+		if elapsed := time.Since(beginTime); elapsed < 150*time.Millisecond {
+			t.Fatalf("download is not limited: %s", elapsed)
+		}
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		pausableRequests.Store(0)
+		pausableHeads.Store(0)
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL:       fmt.Sprintf("%s/target_pausable.bin", server.URL),
+			RateLimit: 8 * 1024,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		var mux sync.Mutex
+		events := []oget.ProgressEvent{}
+		started := make(chan struct{})
+		var startOnce sync.Once
+		done := make(chan error, 1)
+
+		go func() {
+			_, err := task.Get(&oget.GettingConfig{
+				FilePath:  filepath.Join(outputPath, "target-paused.bin"),
+				PartsPath: partsPath,
+				Parts:     2,
+				SHA512:    sha512Code,
+				ListenProgress: func(event oget.ProgressEvent) {
+					mux.Lock()
+					events = append(events, event)
+					mux.Unlock()
+					if event.Progress > 0 {
+						startOnce.Do(func() { close(started) })
+					}
+				},
+			})
+			done <- err
+		}()
+		<-started
+		task.Pause()
+
+		if !task.Paused() {
+			t.Fatalf("task is not paused")
+		}
+		time.Sleep(100 * time.Millisecond)
+		requests := pausableRequests.Load()
+
+		select {
+		case err := <-done:
+			t.Fatalf("download is not paused: %v", err)
+		case <-time.After(300 * time.Millisecond):
+		}
+		if pausableRequests.Load() != requests {
+			t.Fatalf("requests are sent while paused")
+		}
+		task.SetRateLimit(0, 0)
+		task.Resume()
+
+		if err := <-done; err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		savedFileCode, err := oget.SHA512(filepath.Join(outputPath, "target-paused.bin"))
+		if err != nil {
+			t.Fatalf("get code of sha512 fail: %s", err)
+		}
+		if sha512Code != savedFileCode {
+			t.Fatalf("unexpected sha512 code: %s", savedFileCode)
+		}
+		// the probe is not sent again.
+		if heads := pausableHeads.Load(); heads != 1 {
+			t.Fatalf("unexpected HEAD requests: %d", heads)
+		}
+		pausedIndex, resumedIndex := -1, -1
+		for i, event := range events {
+			if event.Paused && pausedIndex < 0 {
+				pausedIndex = i
+			}
+			if !event.Paused && pausedIndex >= 0 && resumedIndex < 0 {
+				resumedIndex = i
+			}
+			if event.Retries != 0 {
+				t.Fatalf("pausing is counted as a retry: %+v", event)
+			}
+		}
+		if pausedIndex < 0 || resumedIndex < 0 {
+			t.Fatalf("pausing and resuming are not fired: %+v", events)
+		}
+		if paused := events[pausedIndex]; paused.Phase != oget.ProgressPhaseDownloading || paused.Progress >= fileLength {
+			t.Fatalf("unexpected paused event: %+v", paused)
+		}
+	})
 
-		for _, event := range events {
-			if lastEvent != nil {
-				if event.Phase < lastEvent.Phase {
-					t.Fatalf("unexpected phase: %d", event.Phase)
-				}
-				if event.Progress < lastEvent.Progress {
-					t.Fatalf("unexpected progress: %d", event.Progress)
-				}
-				if event.Phase > lastEvent.Phase {
-					phaseCount = 0
+	t.Run("download from mirrors", func(t *testing.T) {
+		task, err := oget.CreateGettingTask(&oget.RemoteFile{
+			URL: fmt.Sprintf("%s/mirror/broken/target.bin", server.URL),
+			Mirrors: []string{
+				fmt.Sprintf("%s/mirror/missing/target.bin", server.URL),
+				fmt.Sprintf("%s/mirror/other/target.bin", server.URL),
+				fmt.Sprintf("%s/mirror/stalled/target.bin", server.URL),
+				fileURL,
+			},
+			StallTimeout: 200 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  filepath.Join(outputPath, "target-mirrors.bin"),
+			PartsPath: partsPath,
+			Parts:     4,
+			SHA512:    sha512Code,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		stats := task.MirrorStats()
+		if len(stats) != 5 {
+			t.Fatalf("unexpected count of mirrors: %d", len(stats))
+		}
+		var statusError oget.HTTPStatusError
+		var mismatchError oget.MirrorMismatchError
+		var stallError oget.StallError
+
+		// the broken and the stalled mirrors cool down after failing, rather than being excluded at once.
+		if stats[0].Failures == 0 || !errors.As(stats[0].Err, &statusError) {
+			t.Fatalf("broken mirror is not failed: %+v", stats[0])
+		}
+		if stats[1].Healthy || stats[1].Requests != 0 || !errors.As(stats[1].Err, &statusError) {
+			t.Fatalf("missing mirror is not excluded: %+v", stats[1])
+		}
+		if stats[2].Healthy || stats[2].Requests != 0 || !errors.As(stats[2].Err, &mismatchError) {
+			t.Fatalf("mirror of another file is not excluded: %+v", stats[2])
+		}
+		if stats[3].Failures == 0 || !errors.As(stats[3].Err, &stallError) {
+			t.Fatalf("stalled mirror is not failed: %+v", stats[3])
+		}
+		if !stats[4].Healthy || stats[4].Failures != 0 || stats[4].Bytes == 0 || stats[4].Speed() <= 0 {
+			t.Fatalf("healthy mirror is not used: %+v", stats[4])
+		}
+		// a mirror which fails once is used again.
+		task, err = oget.CreateGettingTask(&oget.RemoteFile{
+			URL:     fileURL,
+			Mirrors: []string{fmt.Sprintf("%s/mirror/flaky/target.bin", server.URL)},
+		})
+		if err != nil {
+			t.Fatalf("create task fail: %s", err)
+		}
+		_, err = task.Get(&oget.GettingConfig{
+			FilePath:  filepath.Join(outputPath, "target-mirrors-flaky.bin"),
+			PartsPath: partsPath,
+			Parts:     8,
+			SHA512:    sha512Code,
+		})
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		stats = task.MirrorStats()
+		if !stats[1].Healthy || stats[1].Failures != 1 || stats[1].Requests < 2 || stats[1].Bytes == 0 {
+			t.Fatalf("flaky mirror is not used again: %+v", stats[1])
+		}
+	})
+
+	t.Run("manage many downloads", func(t *testing.T) {
+		var eventMux sync.Mutex
+		var lastEvent oget.ManagerProgressEvent
+		maxRunning := 0
+
+		manager := oget.NewManager(&oget.ManagerConfig{
+			Workers:               3,
+			MaxConnectionsPerHost: 4,
+			ListenProgress: func(event oget.ManagerProgressEvent) {
+				eventMux.Lock()
+				defer eventMux.Unlock()
+				lastEvent = event
+				maxRunning = max(maxRunning, event.Running)
+			},
+		})
+		defer manager.Close()
+
+		// the job never ends until it is canceled.
+		stalled := manager.Add(&oget.OGet{
+			URL:       fmt.Sprintf("%s/mirror/stalled/target.bin", server.URL),
+			FilePath:  filepath.Join(outputPath, "target-stalled.bin"),
+			PartsPath: partsPath,
+			Parts:     2,
+		})
+		jobs := []*oget.Job{}
+		for i := 0; i < 3; i++ {
+			jobs = append(jobs, manager.Add(&oget.OGet{
+				URL:       fileURL,
+				FilePath:  filepath.Join(outputPath, fmt.Sprintf("target-managed-%d.bin", i)),
+				PartsPath: partsPath,
+				Parts:     2,
+				SHA512:    sha512Code,
+			}))
+		}
+		// waits for the jobs which share the host with the stalled one.
+		<-jobs[2].Done()
+
+		if state := stalled.State(); state != oget.JobRunning {
+			t.Fatalf("unexpected state of stalled job: %d", state)
+		}
+		stalled.Cancel()
+		results := manager.Wait()
+
+		if len(results) != 4 || results[0].Job != stalled || !errors.Is(results[0].Err, context.Canceled) {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if err := results[0].Result.Clean(); err != nil {
+			t.Fatalf("clean fail: %s", err)
+		}
+		for i, job := range jobs {
+			result := job.Wait()
+			if result.Err != nil || job.State() != oget.JobSucceeded {
+				t.Fatalf("download file: %s", result.Err)
+			}
+			code, err := oget.SHA512(filepath.Join(outputPath, fmt.Sprintf("target-managed-%d.bin", i)))
+			if err != nil || code != sha512Code {
+				t.Fatalf("unexpected sha512 of job %d: %s", job.ID(), code)
+			}
+		}
+		eventMux.Lock()
+		defer eventMux.Unlock()
+
+		if maxRunning != 2 {
+			t.Fatalf("unexpected max running jobs: %d", maxRunning)
+		}
+		if lastEvent.Succeeded != 3 || lastEvent.Canceled != 1 || lastEvent.Running != 0 || lastEvent.Pending != 0 {
+			t.Fatalf("unexpected last event: %+v", lastEvent)
+		}
+		// the stalled job has downloaded some bytes.
+		if lastEvent.Progress <= 3*fileLength || lastEvent.Progress >= lastEvent.Total || lastEvent.Total != 4*fileLength {
+			t.Fatalf("unexpected progress: %d/%d", lastEvent.Progress, lastEvent.Total)
+		}
+	})
+
+	t.Run("encode progress as json lines", func(t *testing.T) {
+		download := func(name string, code string) (*bytes.Buffer, error) {
+			buffer := &bytes.Buffer{}
+			encoder := oget.NewJSONLinesEncoder(buffer)
+			savedFilePath := filepath.Join(outputPath, name)
+			result, err := (&oget.OGet{
+				URL:              fileURL,
+				FilePath:         savedFilePath,
+				PartsPath:        partsPath,
+				Parts:            2,
+				SHA512:           code,
+				ListenProgress:   encoder.Listen,
+				ProgressInterval: -1,
+			}).Get()
+			if encodeErr := encoder.EncodeResult(result, err); encodeErr != nil {
+				t.Fatalf("encode fail: %s", encodeErr)
+			}
+			return buffer, err
+		}
+		buffer, err := download("target-json.bin", sha512Code)
+		if err != nil {
+			t.Fatalf("download file: %s", err)
+		}
+		parts := map[int]bool{}
+		phases := []string{}
+		lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+		for _, line := range lines[:len(lines)-1] {
+			var record oget.JSONProgressRecord
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("invalid line %q: %s", line, err)
+			}
+			if record.Schema != oget.JSONSchemaVersion || record.Type != oget.JSONRecordProgress || record.Time.IsZero() {
+				t.Fatalf("unexpected record: %s", line)
+			}
+			if record.Part != nil {
+				parts[*record.Part] = true
+			}
+			if len(phases) == 0 || phases[len(phases)-1] != record.Phase {
+				phases = append(phases, record.Phase)
+			}
+		}
+		if !parts[0] || !parts[1] || len(parts) != 2 {
+			t.Fatalf("unexpected parts: %v", parts)
+		}
+		if strings.Join(phases, ",") != "downloading,verifying,copying,done" {
+			t.Fatalf("unexpected phases: %v", phases)
+		}
+		var result oget.JSONResultRecord
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil || !result.OK || result.Error != nil {
+			t.Fatalf("unexpected result: %s", lines[len(lines)-1])
+		}
+		if result.Path != filepath.Join(outputPath, "target-json.bin") || result.URL != fileURL ||
+			result.Downloaded != fileLength || result.Digests["sha512"] != sha512Code {
+			t.Fatalf("unexpected result: %s", lines[len(lines)-1])
+		}
+		buffer, err = download("target-json-wrong.bin", strings.Repeat("0", 128))
+		if err == nil {
+			t.Fatalf("download with wrong checksum must fail")
+		}
+		lines = strings.Split(strings.TrimSpace(buffer.String()), "\n")
+		result = oget.JSONResultRecord{}
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil || result.OK ||
+			result.Error.Kind != oget.ErrorKindChecksum || result.Error.Retryable {
+			t.Fatalf("unexpected result: %s", lines[len(lines)-1])
+		}
+		if oget.ErrorKindOf(oget.RangeNotSupportedError{}) != oget.ErrorKindRangeNotSupported {
+			t.Fatalf("unexpected kind of RangeNotSupportedError")
+		}
+	})
+
+	t.Run("command line tool", func(t *testing.T) {
+		binPath := filepath.Join(t.TempDir(), "oget")
+		if output, err := exec.Command("go", "build", "-o", binPath, "../cmd/oget").CombinedOutput(); err != nil {
+			t.Fatalf("build command fail: %s\n%s", err, output)
+		}
+		runCommand := func(args ...string) int {
+			cmd := exec.Command(binPath, args...)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				var exitError *exec.ExitError
+				if !errors.As(err, &exitError) {
+					t.Fatalf("run command fail: %s\n%s", err, output)
 				}
 			}
-			lastEvent = &event
-			phaseCount += 1
+			return cmd.ProcessState.ExitCode()
 		}
-		if lastEvent == nil {
-			t.Fatalf("no progress event")
+		savedFilePath := filepath.Join(outputPath, "target-command.bin")
+
+		if code := runCommand("-o", savedFilePath, "-parts", "3", "-parts-path", partsPath, "-sha512", sha512Code, fileURL); code != 0 {
+			t.Fatalf("unexpected exit code: %d", code)
 		}
-		if lastEvent.Phase != oget.ProgressPhaseDone {
-			t.Fatalf("unexpected phase: %d", lastEvent.Phase)
+		if code, err := oget.SHA512(savedFilePath); err != nil || code != sha512Code {
+			t.Fatalf("unexpected sha512 code: %s", code)
 		}
-		if phaseCount != 1 {
-			t.Fatalf("unexpected phase count: %d", phaseCount)
+		wrongCode := strings.Repeat("0", 128)
+		if code := runCommand("-o", filepath.Join(outputPath, "target-command-wrong.bin"), "-sha512", wrongCode, fileURL); code != 4 {
+			t.Fatalf("unexpected exit code of integrity failure: %d", code)
+		}
+		if code := runCommand("-o", filepath.Join(outputPath, "target-command-missing.bin"), fmt.Sprintf("%s/missing.bin", server.URL)); code != 3 {
+			t.Fatalf("unexpected exit code of network failure: %d", code)
+		}
+		output, _ := exec.Command(binPath, "-json", "-o", filepath.Join(outputPath, "target-command-missing.bin"), fmt.Sprintf("%s/missing.bin", server.URL)).Output()
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		var result oget.JSONResultRecord
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil ||
+			result.Error == nil || result.Error.Kind != oget.ErrorKindHTTPStatus || result.Error.StatusCode != http.StatusNotFound {
+			t.Fatalf("unexpected json output: %s", output)
+		}
+		if code := runCommand("-parts", "0", fileURL); code != 2 {
+			t.Fatalf("unexpected exit code of usage: %d", code)
+		}
+		if code := runCommand("-o", filepath.Join(outputPath, "target-command-config.bin"), "-sha512", "not hex", fileURL); code != 2 {
+			t.Fatalf("unexpected exit code of invalid checksum: %d", code)
 		}
 	})
 
@@ -166,6 +1681,10 @@ func TestAll(t *testing.T) {
 		if fmt.Sprintf("%s", err) != "download bytes is less than expected" {
 			t.Fatalf("unexpected error: %s", err)
 		}
+		var lengthError oget.ContentLengthError
+		if !errors.As(err, &lengthError) || !oget.IsRetryable(err) {
+			t.Fatalf("unexpected error: %s", err)
+		}
 		err = tryDownload(false)
 
 		if err != nil {
@@ -201,6 +1720,29 @@ func setupDownloadPath(t *testing.T) (string, string) {
 	return outputPath, partsPath
 }
 
+var changingETag atomic.Value
+
+// the number of the requests of /target_pausable.bin, and the HEAD ones of them.
+var pausableRequests, pausableHeads atomic.Int32
+
+// a hash which sums the number of bytes, to test registering a hash.
+type lengthHash struct {
+	length uint64
+}
+
+func (h *lengthHash) Write(p []byte) (int, error) {
+	h.length += uint64(len(p))
+	return len(p), nil
+}
+
+func (h *lengthHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.length)
+}
+
+func (h *lengthHash) Reset()         { h.length = 0 }
+func (h *lengthHash) Size() int      { return 8 }
+func (h *lengthHash) BlockSize() int { return 1 }
+
 func createTestServer(t *testing.T) *httptest.Server {
 	targetPath, err := filepath.Abs("./target.bin")
 
@@ -211,6 +1753,243 @@ func createTestServer(t *testing.T) *httptest.Server {
 	mux.HandleFunc("/target.bin", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, targetPath)
 	})
+	mux.HandleFunc("/target_digest.bin", createDigestHandler(targetPath, false))
+	mux.HandleFunc("/target_wrong_digest.bin", createDigestHandler(targetPath, true))
+	mux.HandleFunc("/release/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/release/SHA256SUMS", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, "%s  other.bin\n", strings.Repeat("0", 64))
+		fmt.Fprintf(w, "477b7109162d1cabc828bd6af1c49cb77bfe199ca1a5c165f981986b6302767f *target.bin\n")
+	})
+	mux.HandleFunc("/release/bad/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/release/bad/target.bin.sha512", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "SHA512 (target.bin) = %s\n", strings.Repeat("0", 128))
+	})
+	mux.HandleFunc("/forbidden/", func(w http.ResponseWriter, r *http.Request) {
+		// responds 403 for the missing files, like S3.
+		if r.URL.Path != "/forbidden/target.bin" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/release/unavailable/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/release/unavailable/target.bin.sha512", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/sums/target.b2", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# b2sum --tag\nBLAKE2b (./target.bin) = 3dddd1db0b4f98a4d3459349decd740695d9836a3395e775141404f792400bd0841e78fc80c2ab3ab949511c11cbc4a7b9649fd9936423618c4d9110ffd876c1\n")
+	})
+	mux.HandleFunc("/target.bin.sig", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(ed25519.Sign(testSigningKey(), content))
+	})
+	mux.HandleFunc("/target_corrupt.bin", createCorruptHandler(targetPath))
+	mux.HandleFunc("/target_corrupt_chunks.bin", createCorruptHandler(targetPath))
+	mux.HandleFunc("/target_corrupt_preallocated.bin", createCorruptHandler(targetPath))
+	mux.HandleFunc("/target_corrupt_unrepaired.bin", createCorruptHandler(targetPath))
+	for _, name := range []string{"target_corrupt_flaky.bin", "target_corrupt_flaky_retried.bin"} {
+		corruptHandler := createCorruptHandler(targetPath)
+		var repairRequests atomic.Int32
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			// the first repair of the corrupted chunk fails.
+			if r.Header.Get("Range") == "bytes=0-4095" && repairRequests.Add(1) == 1 {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			corruptHandler(w, r)
+		})
+	}
+	mux.HandleFunc("/target.bin.chunks", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		chunks := oget.Chunks{Algorithm: "sha256", Size: 4096}
+		for i := 0; i < len(content); i += 4096 {
+			chunks.Digests = append(chunks.Digests, fmt.Sprintf("%x", sha256.Sum256(content[i:min(i+4096, len(content))])))
+		}
+		_ = json.NewEncoder(w).Encode(chunks)
+	})
+	corruptMirror := createCorruptHandler(targetPath)
+	var corruptMirrorRequests atomic.Int32
+	mux.HandleFunc("/mirror/corrupt/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead && r.Header.Get("Range") != "bytes=0-0" && corruptMirrorRequests.Add(1) > 1 {
+			// the corrupted bytes can only be repaired from another mirror.
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		corruptMirror(w, r)
+	})
+	var flakyMirrorRequests atomic.Int32
+	mux.HandleFunc("/mirror/flaky/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead && r.Header.Get("Range") != "bytes=0-0" && flakyMirrorRequests.Add(1) == 1 {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/mirror/broken/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/mirror/other/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "target.bin", time.Time{}, bytes.NewReader(content[:len(content)/2]))
+	})
+	mux.HandleFunc("/mirror/stalled/target.bin", func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader := r.Header.Get("Range")
+		if r.Method == http.MethodHead || rangeHeader == "" {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		// sends some bytes of the range, then nothing until the request is canceled.
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		ranges := strings.Split(rangeHeader, "=")
+		offset := strings.Split(ranges[1], "-")
+		startByte, _ := strconv.Atoi(offset[0])
+		endByte, _ := strconv.Atoi(offset[1])
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(endByte-startByte+1))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[startByte : startByte+min(1024, endByte-startByte+1)])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/target_no_head.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/target_no_range.bin", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(content)
+		}
+	})
+	mux.HandleFunc("/empty.bin", func(w http.ResponseWriter, r *http.Request) {
+		// responds 200 with "Content-Length: 0", even for a range request.
+		http.ServeContent(w, r, "empty.bin", time.Time{}, bytes.NewReader(nil))
+	})
+	mux.HandleFunc("/empty_unsatisfiable.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes */0")
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "0")
+	})
+	mux.HandleFunc("/target_chunked.bin", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+
+		if r.Method == http.MethodHead {
+			return
+		}
+		chunkSize := 8192
+		for i := 0; i < len(content); i += chunkSize {
+			_, _ = w.Write(content[i:min(i+chunkSize, len(content))])
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/target_flaky.bin", createFlakyHandler(targetPath))
+	mux.HandleFunc("/target_flaky_resume.bin", createFlakyHandler(targetPath))
+	mux.HandleFunc("/target_flaky_preallocated.bin", createFlakyHandler(targetPath))
+	mux.HandleFunc("/target_ignore_range.bin", func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		// advertises range support, but always responds the whole file.
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(content)
+		}
+	})
+	mux.HandleFunc("/target_hanging.bin", func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader := r.Header.Get("Range")
+		if r.Method == http.MethodHead || rangeHeader == "" || rangeHeader == "bytes=0-0" {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		offset := strings.Split(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+		startByte, _ := strconv.Atoi(offset[0])
+		endByte, _ := strconv.Atoi(offset[1])
+
+		// sends all bytes but the last one, and hangs until the client gives up.
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[startByte:endByte])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/target_slow.bin", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/target_pausable.bin", func(w http.ResponseWriter, r *http.Request) {
+		pausableRequests.Add(1)
+		if r.Method == http.MethodHead {
+			pausableHeads.Add(1)
+		}
+		http.ServeFile(w, r, targetPath)
+	})
+	mux.HandleFunc("/target_changing.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", changingETag.Load().(string))
+		http.ServeFile(w, r, targetPath)
+	})
 	mux.HandleFunc("/target_fail.bin", func(w http.ResponseWriter, r *http.Request) {
 		file, err := os.Open(targetPath)
 		if err != nil {
@@ -240,6 +2019,7 @@ func createTestServer(t *testing.T) *httptest.Server {
 			endByte, _ := strconv.Atoi(offset[1])
 
 			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte, fileInfo.Size()))
+			w.WriteHeader(http.StatusPartialContent)
 
 			rangeLength := int64(endByte - startByte + 1)
 			copySize := rangeLength
@@ -255,3 +2035,108 @@ func createTestServer(t *testing.T) *httptest.Server {
 	})
 	return httptest.NewServer(mux)
 }
+
+// the first request of each part will be interrupted halfway.
+func testSigningKey() ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+}
+
+// serves the file with the headers of its digests. the SHA512 digest is wrong if wrong is true.
+func createDigestHandler(targetPath string, wrong bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encode := func(h hash.Hash) string {
+			h.Write(content)
+			return base64.StdEncoding.EncodeToString(h.Sum(nil))
+		}
+		sha512Digest := encode(sha512.New())
+		if wrong {
+			sha512Digest = base64.StdEncoding.EncodeToString(make([]byte, sha512.Size))
+		}
+		w.Header().Set("Digest", fmt.Sprintf("SHA-256=%s, UNIXsum=30637", encode(sha256.New())))
+		w.Header().Set("Repr-Digest", fmt.Sprintf("sha-512=:%s:", sha512Digest))
+		w.Header().Set("Content-MD5", encode(md5.New()))
+		w.Header().Add("X-Goog-Hash", fmt.Sprintf("crc32c=%s", encode(crc32.New(crc32.MakeTable(crc32.Castagnoli)))))
+		w.Header().Add("X-Goog-Hash", fmt.Sprintf("md5=%s", encode(md5.New())))
+		http.ServeFile(w, r, targetPath)
+	}
+}
+
+// serves the file, but corrupts a byte of the first response of the range starting from byte 0.
+// the requested ranges are counted by the handler.
+func createCorruptHandler(targetPath string) http.HandlerFunc {
+	var corruptMux sync.Mutex
+	requestedRanges := map[string]int{}
+	corrupted := false
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader := r.Header.Get("Range")
+		if r.Method == http.MethodHead || rangeHeader == "bytes=0-0" {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		corruptMux.Lock()
+		requestedRanges[rangeHeader] += 1
+		w.Header().Set("X-Range-Count", strconv.Itoa(requestedRanges[rangeHeader]))
+		corrupt := !corrupted && strings.HasPrefix(rangeHeader, "bytes=0-")
+		corrupted = corrupted || corrupt
+		corruptMux.Unlock()
+
+		if !corrupt {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+		endByte, _ := strconv.Atoi(strings.TrimPrefix(rangeHeader, "bytes=0-"))
+		body := append([]byte{}, content[:endByte+1]...)
+		body[100] ^= 0xff
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", endByte, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(body)
+	}
+}
+
+func createFlakyHandler(targetPath string) http.HandlerFunc {
+	var flakyMux sync.Mutex
+	flakyRanges := map[string]bool{}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader := r.Header.Get("Range")
+		if r.Method == http.MethodHead || rangeHeader == "" || rangeHeader == "bytes=0-0" {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		ranges := strings.Split(rangeHeader, "=")
+		offset := strings.Split(ranges[1], "-")
+		startByte, _ := strconv.Atoi(offset[0])
+		endByte, _ := strconv.Atoi(offset[1])
+
+		flakyMux.Lock()
+		failed := flakyRanges[offset[1]]
+		flakyRanges[offset[1]] = true
+		flakyMux.Unlock()
+
+		if failed {
+			http.ServeFile(w, r, targetPath)
+			return
+		}
+		content, err := os.ReadFile(targetPath)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error reading file: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(content[startByte : startByte+(endByte-startByte+1)/2])
+	}
+}
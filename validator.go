@@ -0,0 +1,38 @@
+package oget
+
+import (
+	"net/http"
+	"strings"
+)
+
+// returns the value to identify the version of the remote file.
+// returns empty string if the server does not tell it.
+func (t *GettingTask) validator() string {
+	if t.etag != "" {
+		return t.etag
+	}
+	return t.lastModified
+}
+
+// returns the value of If-Range header, which must be a strong ETag or a date.
+// every mirror has its own validators, because the servers may tell different ones for the same file.
+func (m *mirror) ifRangeValidator() string {
+	if m.etag != "" && !strings.HasPrefix(m.etag, "W/") {
+		return m.etag
+	}
+	return m.lastModified
+}
+
+// returns RemoteChangedError if the response belongs to another version of the remote file.
+func (m *mirror) checkRemoteUnchanged(resp *http.Response) error {
+	if m.etag != "" {
+		if etag := resp.Header.Get("ETag"); etag != "" && etag != m.etag {
+			return RemoteChangedError{Expected: m.etag, Actual: etag}
+		}
+	} else if m.lastModified != "" {
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" && lastModified != m.lastModified {
+			return RemoteChangedError{Expected: m.lastModified, Actual: lastModified}
+		}
+	}
+	return nil
+}